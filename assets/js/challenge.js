@@ -0,0 +1,118 @@
+// Solves the proof-of-work challenge served by the ChallengeScrapers middleware.
+// Finds a nonce such that SHA-256(challenge + nonce) has at least `difficulty` leading zero bits,
+// then submits it to /challenge.
+//
+// NOTE: SHA-256 is implemented here instead of using crypto.subtle, since crypto.subtle
+// is unavailable on plain HTTP origins (e.g. onion services).
+
+const K = new Uint32Array([
+  0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+  0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+  0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+  0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+  0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+  0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+  0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+  0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+]);
+
+const W = new Uint32Array(64);
+
+function rotr(x, n) {
+  return (x >>> n) | (x << (32 - n));
+}
+
+// sha256 hashes an ASCII string and returns the digest as 8 32-bit words
+function sha256(message) {
+  const length = message.length;
+  const blockCount = ((length + 8) >> 6) + 1;
+  const words = new Uint32Array(blockCount * 16);
+  for (let i = 0; i < length; i++) {
+    words[i >> 2] |= (message.charCodeAt(i) & 0xff) << (24 - (i % 4) * 8);
+  }
+  words[length >> 2] |= 0x80 << (24 - (length % 4) * 8);
+  words[blockCount * 16 - 1] = length * 8;
+
+  const H = new Uint32Array([
+    0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+  ]);
+
+  for (let block = 0; block < words.length; block += 16) {
+    for (let t = 0; t < 64; t++) {
+      if (t < 16) {
+        W[t] = words[block + t];
+      } else {
+        const s0 = rotr(W[t - 15], 7) ^ rotr(W[t - 15], 18) ^ (W[t - 15] >>> 3);
+        const s1 = rotr(W[t - 2], 17) ^ rotr(W[t - 2], 19) ^ (W[t - 2] >>> 10);
+        W[t] = W[t - 16] + s0 + W[t - 7] + s1;
+      }
+    }
+
+    let [a, b, c, d, e, f, g, h] = H;
+    for (let t = 0; t < 64; t++) {
+      const S1 = rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25);
+      const ch = (e & f) ^ (~e & g);
+      const temp1 = (h + S1 + ch + K[t] + W[t]) | 0;
+      const S0 = rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22);
+      const maj = (a & b) ^ (a & c) ^ (b & c);
+      const temp2 = (S0 + maj) | 0;
+      h = g;
+      g = f;
+      f = e;
+      e = (d + temp1) | 0;
+      d = c;
+      c = b;
+      b = a;
+      a = (temp1 + temp2) | 0;
+    }
+
+    H[0] += a;
+    H[1] += b;
+    H[2] += c;
+    H[3] += d;
+    H[4] += e;
+    H[5] += f;
+    H[6] += g;
+    H[7] += h;
+  }
+
+  return H;
+}
+
+function leadingZeroBits(digest) {
+  let count = 0;
+  for (const word of digest) {
+    if (word !== 0) {
+      return count + Math.clz32(word);
+    }
+    count += 32;
+  }
+  return count;
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+  const form = document.getElementById("challenge-form");
+  if (!form) return;
+
+  const status = document.getElementById("challenge-status");
+  const challenge = form.elements["challenge"].value;
+  const difficulty = parseInt(form.getAttribute("data-difficulty"), 10);
+
+  let nonce = 0;
+
+  // Work in small batches so the page stays responsive
+  function work() {
+    for (let i = 0; i < 5000; i++, nonce++) {
+      if (leadingZeroBits(sha256(challenge + nonce)) >= difficulty) {
+        form.elements["nonce"].value = nonce.toString();
+        status.textContent = "Done! Redirecting...";
+        form.submit();
+        return;
+      }
+    }
+    status.textContent = "Solving... (" + nonce + " attempts)";
+    setTimeout(work, 0);
+  }
+
+  work();
+});
@@ -3,6 +3,8 @@ package config
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"net/url"
 	"strings"
@@ -58,9 +60,37 @@ type ServerConfig struct {
 	AcceptLanguage string `env:"PIXIVFE_ACCEPTLANGUAGE,overwrite"`
 	RequestLimit   uint64 `env:"PIXIVFE_REQUESTLIMIT"` // if 0, request limit is disabled
 
+	// Per route class request limits. If 0, derived from RequestLimit
+	RequestLimitMulti  uint64 `env:"PIXIVFE_REQUESTLIMIT_MULTI"`
+	RequestLimitSearch uint64 `env:"PIXIVFE_REQUESTLIMIT_SEARCH"`
+	RequestLimitProxy  uint64 `env:"PIXIVFE_REQUESTLIMIT_PROXY"`
+	RequestLimitAction uint64 `env:"PIXIVFE_REQUESTLIMIT_ACTION"`
+
 	ProxyServer_staging string  `env:"PIXIVFE_IMAGEPROXY,overwrite"`
 	ProxyServer         url.URL // proxy server URL, may or may not contain authority part of the URL
 
+	// Proof-of-work challenge against scrapers
+	ChallengeEnabled           bool          `env:"PIXIVFE_CHALLENGE_ENABLED"`
+	ChallengeDifficulty        int           `env:"PIXIVFE_CHALLENGE_DIFFICULTY,overwrite"` // number of leading zero bits
+	ChallengeSecret            string        `env:"PIXIVFE_CHALLENGE_SECRET"`               // HMAC key. if empty, a random one is generated at startup
+	ChallengeClearanceDuration time.Duration `env:"PIXIVFE_CHALLENGE_CLEARANCE_DURATION,overwrite"`
+	ChallengeExemptPaths       []string      `env:"PIXIVFE_CHALLENGE_EXEMPT_PATHS,overwrite"`
+	ChallengeAllowedUserAgents []string      `env:"PIXIVFE_CHALLENGE_ALLOWED_USER_AGENTS"`
+	ChallengeAllowedIPs        []string      `env:"PIXIVFE_CHALLENGE_ALLOWED_IPS"`     // IP addresses or CIDR ranges
+	ChallengeTrustedProxies    []string      `env:"PIXIVFE_CHALLENGE_TRUSTED_PROXIES"` // reverse proxies whose X-Forwarded-For is used. IP addresses or CIDR ranges
+
+	// Built-in image proxy options
+	ImageResizeEnabled bool   `env:"PIXIVFE_IMAGE_RESIZE_ENABLED"`
+	ImageCacheEnabled  bool   `env:"PIXIVFE_IMAGE_CACHE_ENABLED"`
+	ImageCacheLocation string `env:"PIXIVFE_IMAGE_CACHE_LOCATION,overwrite"`
+	ImageCacheSize     uint64 `env:"PIXIVFE_IMAGE_CACHE_SIZE,overwrite"` // in MiB. if 0, the cache size is unlimited
+
+	ProxyMaxBodySize uint64 `env:"PIXIVFE_PROXY_MAX_BODY_SIZE,overwrite"` // in MiB. if 0, proxied responses are not limited
+
+	// File names of the images in artwork archives, see image_proxy.ArchiveFilename
+	DownloadFilenameTemplate string `env:"PIXIVFE_DOWNLOAD_FILENAME_TEMPLATE,overwrite"`
+
+	ProxyList          []string      `env:"PIXIVFE_PROXY_LIST"` // if empty, BuiltinProxyList is used
 	ProxyCheckEnabled  bool          `env:"PIXIVFE_PROXY_CHECK_ENABLED,overwrite"`
 	ProxyCheckInterval time.Duration `env:"PIXIVFE_PROXY_CHECK_INTERVAL,overwrite"`
 	ProxyCheckTimeout  time.Duration `env:"PIXIVFE_PROXY_CHECK_TIMEOUT,overwrite"`
@@ -147,6 +177,15 @@ func (s *ServerConfig) LoadConfig() error {
 	s.APIBaseTimeout = 500 * time.Millisecond
 	s.APIMaxBackoffTime = 8000 * time.Millisecond
 
+	s.ChallengeDifficulty = 16
+	s.ChallengeClearanceDuration = 24 * time.Hour
+	s.ChallengeExemptPaths = []string{"/img/", "/css/", "/js/", "/robots.txt", "/oembed", "/users/*.atom.xml", "/users/*/*.atom.xml"}
+
+	s.ImageCacheLocation = "/tmp/pixivfe/images"
+	s.ImageCacheSize = 1024
+	s.ProxyMaxBodySize = 100
+	s.DownloadFilenameTemplate = "{artist}_{id}_p{page}"
+
 	s.ResponseSaveLocation = "/tmp/pixivfe/responses"
 
 	s.LogLevel = "info"
@@ -181,6 +220,49 @@ func (s *ServerConfig) LoadConfig() error {
 	s.ProxyServer = *proxyURL
 	log.Printf("Proxy check interval set to: %v\n", s.ProxyCheckInterval)
 
+	if s.ImageResizeEnabled {
+		log.Println("Image resizing enabled.")
+	}
+	if s.ImageCacheEnabled {
+		log.Println("Image caching enabled.")
+		log.Printf("Image cache location: %s, size limit: %d MiB\n", s.ImageCacheLocation, s.ImageCacheSize)
+	}
+
+	// Derive per route class request limits from RequestLimit when unset
+	if s.RequestLimit > 0 {
+		if s.RequestLimitMulti == 0 {
+			s.RequestLimitMulti = s.RequestLimit * 2
+		}
+		if s.RequestLimitSearch == 0 {
+			s.RequestLimitSearch = s.RequestLimit
+		}
+		if s.RequestLimitProxy == 0 {
+			s.RequestLimitProxy = s.RequestLimit * 20
+		}
+		if s.RequestLimitAction == 0 {
+			s.RequestLimitAction = s.RequestLimit
+		}
+		log.Printf("Request limits per 30s: page: %d, multi: %d, search: %d, proxy: %d, action: %d\n",
+			s.RequestLimit, s.RequestLimitMulti, s.RequestLimitSearch, s.RequestLimitProxy, s.RequestLimitAction)
+	}
+
+	// Validate proof-of-work challenge settings
+	if s.ChallengeEnabled {
+		if s.ChallengeDifficulty < 1 || s.ChallengeDifficulty > 32 {
+			log.Printf("[WARNING] Invalid PIXIVFE_CHALLENGE_DIFFICULTY value: %d. Defaulting to 16.\n", s.ChallengeDifficulty)
+			s.ChallengeDifficulty = 16
+		}
+		if s.ChallengeSecret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return err
+			}
+			s.ChallengeSecret = hex.EncodeToString(secret)
+			log.Printf("[WARNING] PIXIVFE_CHALLENGE_SECRET is not set. Using a random secret; clearances will not survive a restart.\n")
+		}
+		log.Printf("Proof-of-work challenge enabled. Difficulty: %d bits, clearance duration: %v\n", s.ChallengeDifficulty, s.ChallengeClearanceDuration)
+	}
+
 	// Validate repo URL
 	repoURL, err := validateURL(s.RepoURL, "Repo")
 	if err != nil {
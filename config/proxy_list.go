@@ -2,6 +2,9 @@ package config
 
 const BuiltinProxyUrl = "/proxy/i.pximg.net" // built-in proxy route
 
+// route that serves i.pximg.net images through the user's image proxy, failing over to other proxies and then to the built-in proxy
+const SmartProxyUrl = "/proxy/smart"
+
 // the list of proxies on /settings
 var BuiltinProxyList = []string{
 	// !!!! WE ARE NOT AFFILIATED WITH MOST OF THE PROXIES !!!!
@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/http"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
@@ -69,6 +68,13 @@ func (x AiType) String() string {
 	return ""
 }
 
+// Pixiv's illustType values
+const (
+	IllustTypeIllust = 0
+	IllustTypeManga  = 1
+	IllustTypeUgoira = 2
+)
+
 type ImageResponse struct {
 	Width  int               `json:"width"`
 	Height int               `json:"height"`
@@ -90,15 +96,6 @@ type Tag struct {
 	TranslatedName string `json:"translation"`
 }
 
-type Comment struct {
-	AuthorID   string `json:"userId"`
-	AuthorName string `json:"userName"`
-	Avatar     string `json:"img"`
-	Context    string `json:"comment"`
-	Stamp      string `json:"stampId"`
-	Date       string `json:"commentDate"`
-}
-
 type UserBrief struct {
 	ID     string `json:"userId"`
 	Name   string `json:"name"`
@@ -106,17 +103,19 @@ type UserBrief struct {
 }
 
 type ArtworkBrief struct {
-	ID           string `json:"id"`
-	Title        string `json:"title"`
-	ArtistID     string `json:"userId"`
-	ArtistName   string `json:"userName"`
-	ArtistAvatar string `json:"profileImageUrl"`
-	Thumbnail    string `json:"url"`
-	Pages        int    `json:"pageCount"`
-	XRestrict    int    `json:"xRestrict"`
-	AiType       int    `json:"aiType"`
-	Bookmarked   any    `json:"bookmarkData"`
-	IllustType   int    `json:"illustType"`
+	ID           string   `json:"id"`
+	Title        string   `json:"title"`
+	ArtistID     string   `json:"userId"`
+	ArtistName   string   `json:"userName"`
+	ArtistAvatar string   `json:"profileImageUrl"`
+	Thumbnail    string   `json:"url"`
+	Pages        int      `json:"pageCount"`
+	XRestrict    int      `json:"xRestrict"`
+	AiType       int      `json:"aiType"`
+	Bookmarked   any      `json:"bookmarkData"`
+	IllustType   int      `json:"illustType"`
+	Tags         []string `json:"tags"`
+	BookmarkID   string   `json:"-"` // only set by GetUserBookmarks
 }
 
 type Illust struct {
@@ -162,9 +161,13 @@ type Illust struct {
 	RecentWorks  []ArtworkBrief
 	RelatedWorks []ArtworkBrief
 	CommentsList []Comment
-	IsUgoira     bool
-	BookmarkID   string
-	IllustType   int `json:"illustType"`
+	// CommentsHasNext is set if there are more root comments than CommentsList holds
+	CommentsHasNext bool
+	IsUgoira        bool
+	BookmarkID      string
+	// BookmarkPrivate is set if the user bookmarked the artwork privately
+	BookmarkPrivate bool
+	IllustType      int `json:"illustType"`
 }
 
 func GetUserBasicInformation(r *http.Request, id string) (UserBrief, error) {
@@ -230,27 +233,6 @@ func GetArtworkImages(r *http.Request, id string, illustType int) ([]Image, erro
 	return images, nil
 }
 
-func GetArtworkComments(r *http.Request, id string) ([]Comment, error) {
-	var body struct {
-		Comments []Comment `json:"comments"`
-	}
-
-	URL := GetArtworkCommentsURL(id)
-
-	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
-	if err != nil {
-		return nil, err
-	}
-	response = session.ProxyImageUrl(r, response)
-
-	err = json.Unmarshal([]byte(response), &body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body.Comments, nil
-}
-
 func GetRelatedArtworks(r *http.Request, id string) ([]ArtworkBrief, error) {
 	var body struct {
 		Illusts []ArtworkBrief `json:"illusts"`
@@ -289,9 +271,10 @@ func GetArtworkByID(r *http.Request, id string, full bool) (*Illust, error) {
 		// missing avatar. if we have avatar, we can skip the user request below
 	}
 	var illust2 struct {
-		Images       []Image
-		RelatedWorks []ArtworkBrief
-		CommentsList []Comment
+		Images          []Image
+		RelatedWorks    []ArtworkBrief
+		CommentsList    []Comment
+		CommentsHasNext bool
 	}
 
 	wg := sync.WaitGroup{}
@@ -318,6 +301,7 @@ func GetArtworkByID(r *http.Request, id string, full bool) (*Illust, error) {
 		if illust.BookmarkData != nil {
 			t := illust.BookmarkData.(map[string]any)
 			illust.BookmarkID = t["id"].(string)
+			illust.BookmarkPrivate, _ = t["private"].(bool)
 		}
 
 		// Get basic user information (the URL above does not contain avatars)
@@ -439,12 +423,13 @@ func GetArtworkByID(r *http.Request, id string, full bool) (*Illust, error) {
 			go func() {
 				defer wg.Done()
 
-				comments, err := GetArtworkComments(r, id)
+				comments, hasNext, err := GetComments(r, CommentTargetIllust, id, 0)
 				if err != nil {
 					cerr <- err
 					return
 				}
 				illust2.CommentsList = comments
+				illust2.CommentsHasNext = hasNext
 			}()
 		}
 	}()
@@ -455,6 +440,7 @@ func GetArtworkByID(r *http.Request, id string, full bool) (*Illust, error) {
 	illust.Images = illust2.Images
 	illust.RelatedWorks = illust2.RelatedWorks
 	illust.CommentsList = illust2.CommentsList
+	illust.CommentsHasNext = illust2.CommentsHasNext
 
 	all_errors := []error{}
 	for suberr := range cerr {
@@ -466,7 +452,7 @@ func GetArtworkByID(r *http.Request, id string, full bool) (*Illust, error) {
 	}
 
 	// If this artwork is an ugoira
-	illust.IsUgoira = strings.Contains(illust.Images[0].Original, "ugoira")
+	illust.IsUgoira = illust.IllustType == IllustTypeUgoira
 
 	return &illust.Illust, nil
 }
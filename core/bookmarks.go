@@ -0,0 +1,59 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+	"github.com/goccy/go-json"
+)
+
+type BookmarkTag struct {
+	Name  string `json:"tag"`
+	Count int    `json:"cnt"`
+}
+
+// BookmarkTags are the tags a user gave to their public and private bookmarks.
+type BookmarkTags struct {
+	Public  []BookmarkTag `json:"public"`
+	Private []BookmarkTag `json:"private"`
+}
+
+// Names returns every tag name once, the most used first.
+func (t BookmarkTags) Names() []string {
+	counts := make(map[string]int)
+	for _, tag := range append(append([]BookmarkTag{}, t.Public...), t.Private...) {
+		counts[tag.Name] += tag.Count
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// GetUserBookmarkTags returns the tags the logged in user, userID, gave to their bookmarks. kind is "illusts" or "novels".
+func GetUserBookmarkTags(r *http.Request, userID, kind string) (BookmarkTags, error) {
+	var tags BookmarkTags
+
+	URL := GetUserBookmarkTagsURL(userID, kind)
+
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
+	if err != nil {
+		return tags, err
+	}
+
+	err = json.Unmarshal([]byte(response), &tags)
+	if err != nil {
+		return tags, err
+	}
+
+	return tags, nil
+}
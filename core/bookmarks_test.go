@@ -0,0 +1,17 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBookmarkTagsNames(t *testing.T) {
+	tags := BookmarkTags{
+		Public:  []BookmarkTag{{Name: "a", Count: 1}, {Name: "b", Count: 3}},
+		Private: []BookmarkTag{{Name: "a", Count: 4}, {Name: "c", Count: 1}},
+	}
+	want := []string{"a", "b", "c"}
+	if got := tags.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+// What a comment thread belongs to
+const (
+	CommentTargetIllust = "illust"
+	CommentTargetNovel  = "novel"
+)
+
+// CommentsPerPage is how many root comments GetComments returns at once
+const CommentsPerPage = 30
+
+type Comment struct {
+	ID         string `json:"id"`
+	AuthorID   string `json:"userId"`
+	AuthorName string `json:"userName"`
+	Avatar     string `json:"img"`
+	Context    string `json:"comment"`
+	Stamp      string `json:"stampId"`
+	Date       string `json:"commentDate"`
+
+	RootID          string `json:"commentRootId"`   // replies only: the root comment of the thread
+	ReplyToUserID   string `json:"replyToUserId"`   // replies only
+	ReplyToUserName string `json:"replyToUserName"` // replies only
+	HasReplies      bool   `json:"hasReplies"`      // root comments only
+	Editable        bool   `json:"editable"`        // whether the logged in user can delete it
+}
+
+// GetComments returns the root comments of an artwork or novel starting at offset,
+// newest first, and whether there are more.
+func GetComments(r *http.Request, target, id string, offset int) ([]Comment, bool, error) {
+	var URL string
+	switch target {
+	case CommentTargetIllust:
+		URL = GetArtworkCommentsURL(id, offset, CommentsPerPage)
+	case CommentTargetNovel:
+		URL = GetNovelCommentsURL(id, offset, CommentsPerPage)
+	default:
+		return nil, false, i18n.Errorf("Invalid comment target: %s", target)
+	}
+
+	return getComments(r, URL)
+}
+
+// GetCommentReplies returns a page of the replies to a root comment, and whether there are more.
+func GetCommentReplies(r *http.Request, target, commentID string, page int) ([]Comment, bool, error) {
+	var URL string
+	switch target {
+	case CommentTargetIllust:
+		URL = GetArtworkCommentRepliesURL(commentID, page)
+	case CommentTargetNovel:
+		URL = GetNovelCommentRepliesURL(commentID, page)
+	default:
+		return nil, false, i18n.Errorf("Invalid comment target: %s", target)
+	}
+
+	return getComments(r, URL)
+}
+
+func getComments(r *http.Request, URL string) ([]Comment, bool, error) {
+	// the user's token tells which comments they can delete
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
+	if err != nil {
+		return nil, false, err
+	}
+	response = session.ProxyImageUrl(r, response)
+
+	return parseComments(response)
+}
+
+func parseComments(response string) ([]Comment, bool, error) {
+	var body struct {
+		Comments []Comment `json:"comments"`
+		HasNext  bool      `json:"hasNext"`
+	}
+
+	err := json.Unmarshal([]byte(response), &body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return body.Comments, body.HasNext, nil
+}
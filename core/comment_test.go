@@ -0,0 +1,32 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseComments(t *testing.T) {
+	response := `{
+		"comments": [
+			{"id": "10", "userId": "1", "userName": "a", "img": "/a.png", "comment": "hi(heart)", "stampId": null, "commentDate": "2024-01-01 00:00", "hasReplies": true, "editable": true},
+			{"id": "11", "userId": "2", "userName": "b", "img": "/b.png", "comment": "", "stampId": "301", "commentDate": "2024-01-02 00:00", "commentRootId": "10", "replyToUserId": "1", "replyToUserName": "a"}
+		],
+		"hasNext": true
+	}`
+
+	comments, hasNext, err := parseComments(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasNext {
+		t.Error("hasNext should be set")
+	}
+
+	want := []Comment{
+		{ID: "10", AuthorID: "1", AuthorName: "a", Avatar: "/a.png", Context: "hi(heart)", Date: "2024-01-01 00:00", HasReplies: true, Editable: true},
+		{ID: "11", AuthorID: "2", AuthorName: "b", Avatar: "/b.png", Stamp: "301", Date: "2024-01-02 00:00", RootID: "10", ReplyToUserID: "1", ReplyToUserName: "a"},
+	}
+	if !reflect.DeepEqual(comments, want) {
+		t.Errorf("got %+v\nwant %+v", comments, want)
+	}
+}
@@ -59,3 +59,91 @@ func GetDiscoveryNovels(r *http.Request, mode string) ([]NovelBrief, error) {
 
 	return novels, nil
 }
+
+// RecommendedUser is a user Pixiv recommends following, with a few of their recent works.
+type RecommendedUser struct {
+	ID         string
+	Name       string
+	Avatar     string
+	IsFollowed bool
+	Artworks   []ArtworkBrief
+}
+
+// recommendedUserInfo is an entry of the "users" list that comes with user recommendations.
+type recommendedUserInfo struct {
+	ID         string `json:"userId"`
+	Name       string `json:"name"`
+	Avatar     string `json:"imageBig"`
+	IsFollowed bool   `json:"isFollowed"`
+}
+
+// buildRecommendedUsers joins recommendations (user ID and their recent illust IDs) with the user
+// details and thumbnails sent alongside them. Users without details are skipped, and so are works
+// without a thumbnail.
+func buildRecommendedUsers(ids []string, illustIDs map[string][]string, users []recommendedUserInfo, artworks map[string]ArtworkBrief) []RecommendedUser {
+	info := make(map[string]recommendedUserInfo, len(users))
+	for _, user := range users {
+		info[user.ID] = user
+	}
+
+	recommended := make([]RecommendedUser, 0, len(ids))
+	for _, id := range ids {
+		user, ok := info[id]
+		if !ok {
+			continue
+		}
+		entry := RecommendedUser{ID: user.ID, Name: user.Name, Avatar: user.Avatar, IsFollowed: user.IsFollowed}
+		for _, illustID := range illustIDs[id] {
+			if artwork, ok := artworks[illustID]; ok {
+				entry.Artworks = append(entry.Artworks, artwork)
+			}
+		}
+		recommended = append(recommended, entry)
+	}
+	return recommended
+}
+
+// GetDiscoveryUsers returns users recommended to the logged in user.
+func GetDiscoveryUsers(r *http.Request, limit int) ([]RecommendedUser, error) {
+	URL := GetDiscoveryUsersURL(limit)
+
+	resp, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
+	if err != nil {
+		return nil, err
+	}
+	resp = session.ProxyImageUrl(r, resp)
+
+	return parseDiscoveryUsers(resp)
+}
+
+func parseDiscoveryUsers(resp string) ([]RecommendedUser, error) {
+	var body struct {
+		RecommendedUsers []struct {
+			ID        string   `json:"userId"`
+			IllustIDs []string `json:"recentIllustIds"`
+		} `json:"recommendedUsers"`
+		Users      []recommendedUserInfo `json:"users"`
+		Thumbnails struct {
+			Illust []ArtworkBrief `json:"illust"`
+		} `json:"thumbnails"`
+	}
+
+	err := json.Unmarshal([]byte(resp), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	artworks := make(map[string]ArtworkBrief, len(body.Thumbnails.Illust))
+	for _, artwork := range body.Thumbnails.Illust {
+		artworks[artwork.ID] = artwork
+	}
+
+	ids := make([]string, 0, len(body.RecommendedUsers))
+	illustIDs := make(map[string][]string, len(body.RecommendedUsers))
+	for _, user := range body.RecommendedUsers {
+		ids = append(ids, user.ID)
+		illustIDs[user.ID] = user.IllustIDs
+	}
+
+	return buildRecommendedUsers(ids, illustIDs, body.Users, artworks), nil
+}
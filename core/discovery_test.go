@@ -0,0 +1,42 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiscoveryUsers(t *testing.T) {
+	resp := `{
+		"recommendedUsers": [
+			{"userId": "1", "recentIllustIds": ["10", "11", "12"]},
+			{"userId": "2", "recentIllustIds": []},
+			{"userId": "3", "recentIllustIds": ["30"]}
+		],
+		"users": [
+			{"userId": "1", "name": "one", "imageBig": "/a.png", "isFollowed": true},
+			{"userId": "2", "name": "two", "imageBig": "/b.png", "isFollowed": false}
+		],
+		"thumbnails": {"illust": [
+			{"id": "10", "title": "ten", "xRestrict": 1},
+			{"id": "12", "title": "twelve"},
+			{"id": "30", "title": "thirty"}
+		]}
+	}`
+
+	users, err := parseDiscoveryUsers(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user 3 has no details and is left out, and so is illust 11 which has no thumbnail
+	want := []RecommendedUser{
+		{ID: "1", Name: "one", Avatar: "/a.png", IsFollowed: true, Artworks: []ArtworkBrief{
+			{ID: "10", Title: "ten", XRestrict: 1},
+			{ID: "12", Title: "twelve"},
+		}},
+		{ID: "2", Name: "two", Avatar: "/b.png"},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("got %+v\nwant %+v", users, want)
+	}
+}
@@ -20,6 +20,11 @@ func GetDiscoveryNovelURL(mode string, limit int) string {
 	return fmt.Sprintf(base, mode, limit)
 }
 
+func GetDiscoveryUsersURL(limit int) string {
+	base := "https://www.pixiv.net/ajax/discovery/users?limit=%d"
+	return fmt.Sprintf(base, limit)
+}
+
 func GetRankingURL(mode, content, date, page string) string {
 	base := "https://www.pixiv.net/ranking.php?format=json&mode=%s&content=%s&date=%s&p=%s"
 	baseNoDate := "https://www.pixiv.net/ranking.php?format=json&mode=%s&content=%s&p=%s"
@@ -61,10 +66,31 @@ func GetUserFullNovelURL(id, ids string) string {
 	return fmt.Sprintf(base, id, ids)
 }
 
-func GetUserBookmarksURL(id, mode string, page int) string {
-	base := "https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=&offset=%d&limit=48&rest=%s"
+// GetUserBookmarksURL returns the URL of a page of a user's bookmarks.
+// mode is "show" for public bookmarks and "hide" for private ones. An empty tag lists every bookmark.
+func GetUserBookmarksURL(id, mode, tag string, page int) string {
+	base := "https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=%s&offset=%d&limit=%d&rest=%s"
+
+	return fmt.Sprintf(base, id, url.QueryEscape(tag), page*BookmarksPerPage, BookmarksPerPage, mode)
+}
+
+// GetUserBookmarkTagsURL returns the URL to list the tags a user gave to their bookmarks. kind is "illusts" or "novels".
+func GetUserBookmarkTagsURL(id, kind string) string {
+	base := "https://www.pixiv.net/ajax/user/%s/%s/bookmark/tags"
+
+	return fmt.Sprintf(base, id, kind)
+}
+
+func GetUserFollowingURL(id, rest string, page int) string {
+	base := "https://www.pixiv.net/ajax/user/%s/following?offset=%d&limit=%d&rest=%s"
 
-	return fmt.Sprintf(base, id, page*48, mode)
+	return fmt.Sprintf(base, id, (page-1)*FollowsPerPage, FollowsPerPage, rest)
+}
+
+func GetUserFollowersURL(id string, page int) string {
+	base := "https://www.pixiv.net/ajax/user/%s/followers?offset=%d&limit=%d"
+
+	return fmt.Sprintf(base, id, (page-1)*FollowsPerPage, FollowsPerPage)
 }
 
 func GetFrequentArtworkTagsURL(ids string) string {
@@ -92,6 +118,12 @@ func GetArtworkInformationURL(id string) string {
 	return fmt.Sprintf(base, id)
 }
 
+func GetUgoiraMetaURL(id string) string {
+	base := "https://www.pixiv.net/ajax/illust/%s/ugoira_meta"
+
+	return fmt.Sprintf(base, id)
+}
+
 func GetArtworkImagesURL(id string) string {
 	base := "https://www.pixiv.net/ajax/illust/%s/pages"
 
@@ -104,10 +136,16 @@ func GetArtworkRelatedURL(id string, limit int) string {
 	return fmt.Sprintf(base, id, limit)
 }
 
-func GetArtworkCommentsURL(id string) string {
-	base := "https://www.pixiv.net/ajax/illusts/comments/roots?illust_id=%s&limit=100"
+func GetArtworkCommentsURL(id string, offset, limit int) string {
+	base := "https://www.pixiv.net/ajax/illusts/comments/roots?illust_id=%s&offset=%d&limit=%d"
 
-	return fmt.Sprintf(base, id)
+	return fmt.Sprintf(base, id, offset, limit)
+}
+
+func GetArtworkCommentRepliesURL(commentID string, page int) string {
+	base := "https://www.pixiv.net/ajax/illusts/comments/replies?comment_id=%s&page=%d"
+
+	return fmt.Sprintf(base, commentID, page)
 }
 
 func GetTagDetailURL(unescapedTag string) string {
@@ -141,10 +179,16 @@ func GetNovelRelatedURL(id string, limit int) string {
 	return fmt.Sprintf(base, id, limit)
 }
 
-func GetNovelCommentsURL(id string) string {
-	base := "https://www.pixiv.net/ajax/novels/comments/roots?novel_id=%s&offset=0&limit=100"
+func GetNovelCommentsURL(id string, offset, limit int) string {
+	base := "https://www.pixiv.net/ajax/novels/comments/roots?novel_id=%s&offset=%d&limit=%d"
 
-	return fmt.Sprintf(base, id)
+	return fmt.Sprintf(base, id, offset, limit)
+}
+
+func GetNovelCommentRepliesURL(commentID string, page int) string {
+	base := "https://www.pixiv.net/ajax/novels/comments/replies?comment_id=%s&page=%d"
+
+	return fmt.Sprintf(base, commentID, page)
 }
 
 func GetNovelSeriesURL(id string) string {
@@ -165,10 +209,18 @@ func GetNovelSeriesContentTitlesURL(id int) string {
 	return fmt.Sprintf(base, id)
 }
 
-func GetInsertIllustURL(novelid, id string) string {
-	base := "https://www.pixiv.net/ajax/novel/%s/insert_illusts?id[]=%s"
+func GetNovelSeriesGlossaryURL(id string) string {
+	base := "https://www.pixiv.net/ajax/novel/series/%s/glossary"
 
-	return fmt.Sprintf(base, novelid, id)
+	return fmt.Sprintf(base, id)
+}
+
+// GetInsertIllustURL returns the URL to look up several illusts inserted in a novel with [pixivimage:] at once.
+func GetInsertIllustURL(novelid string, ids []string) string {
+	base := "https://www.pixiv.net/ajax/novel/%s/insert_illusts?%s"
+
+	query := url.Values{"id[]": ids}
+	return fmt.Sprintf(base, novelid, query.Encode())
 }
 
 func GetMangaSeriesContentURL(id string, page int) string {
@@ -176,3 +228,11 @@ func GetMangaSeriesContentURL(id string, page int) string {
 
 	return fmt.Sprintf(base, id, page)
 }
+
+func GetMuteItemsURL() string {
+	return "https://www.pixiv.net/ajax/mute/items?context=setting"
+}
+
+func GetMuteItemsAddURL() string {
+	return "https://www.pixiv.net/ajax/mute/items/add"
+}
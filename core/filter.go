@@ -0,0 +1,176 @@
+package core
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+// Filter hides works from listings according to the user's preferences:
+// the R-18, R-18G and AI filters, and the mute list.
+//
+// Every method returns the kept items along with how many works were hidden,
+// so that pages can tell the user about them.
+type Filter struct {
+	HideR18  bool
+	HideR18G bool
+	HideAI   bool
+	Mute     MuteList
+}
+
+// GetFilter returns the filter saved in the user's cookies.
+func GetFilter(r *http.Request) Filter {
+	return Filter{
+		HideR18:  session.GetCookie(r, session.Cookie_HideArtR18) != "",
+		HideR18G: session.GetCookie(r, session.Cookie_HideArtR18G) != "",
+		HideAI:   session.GetCookie(r, session.Cookie_HideArtAI) != "",
+		Mute:     GetMuteList(r),
+	}
+}
+
+func (f Filter) Empty() bool {
+	return !f.HideR18 && !f.HideR18G && !f.HideAI && f.Mute.Empty()
+}
+
+// WithoutMutedUsers returns the filter without the muted users.
+// It is used on pages about a single user, which the user opened on purpose.
+func (f Filter) WithoutMutedUsers() Filter {
+	f.Mute.Users = nil
+	return f
+}
+
+// HidesRestriction reports whether works with this age restriction are hidden.
+func (f Filter) HidesRestriction(xRestrict XRestrict) bool {
+	return (xRestrict == R18 && f.HideR18) || (xRestrict == R18G && f.HideR18G)
+}
+
+// Hides reports whether a work is hidden.
+func (f Filter) Hides(xRestrict XRestrict, aiType AiType, userID string, tags []string) bool {
+	return f.HidesRestriction(xRestrict) ||
+		(aiType == AI && f.HideAI) ||
+		f.Mute.Mutes(userID, tags)
+}
+
+// filterList returns the items of list that are not hidden, and how many were.
+// The original slice is not modified.
+func filterList[T any](list []T, hides func(T) bool) ([]T, int) {
+	kept := slices.DeleteFunc(slices.Clone(list), hides)
+	return kept, len(list) - len(kept)
+}
+
+// HidesArtwork reports whether an artwork is hidden.
+func (f Filter) HidesArtwork(artwork ArtworkBrief) bool {
+	return f.Hides(XRestrict(artwork.XRestrict), AiType(artwork.AiType), artwork.ArtistID, artwork.Tags)
+}
+
+// HidesNovel reports whether a novel is hidden.
+func (f Filter) HidesNovel(novel NovelBrief) bool {
+	return f.Hides(XRestrict(novel.XRestrict), AiType(novel.AiType), novel.UserID, novel.Tags)
+}
+
+// Artworks returns the artworks that are not hidden.
+func (f Filter) Artworks(artworks []ArtworkBrief) ([]ArtworkBrief, int) {
+	if f.Empty() {
+		return artworks, 0
+	}
+	return filterList(artworks, f.HidesArtwork)
+}
+
+// Novels returns the novels that are not hidden.
+func (f Filter) Novels(novels []NovelBrief) ([]NovelBrief, int) {
+	if f.Empty() {
+		return novels, 0
+	}
+	return filterList(novels, f.HidesNovel)
+}
+
+// Ranking returns the ranking without hidden works. The other works keep their ranks.
+func (f Filter) Ranking(ranking Ranking) (Ranking, int) {
+	if f.Empty() {
+		return ranking, 0
+	}
+	var hidden int
+	ranking.Contents, hidden = filterList(ranking.Contents, func(work RankingArtwork) bool {
+		// AI-generated works are not ranked by Pixiv
+		return f.Hides(XRestrict(work.XRestrict), Unrated, strconv.Itoa(work.ArtistID), work.Tags)
+	})
+	return ranking, hidden
+}
+
+// RankingCalendar removes the artworks from the days of a calendar of this ranking mode
+// if the mode is hidden. The days themselves are kept so that the calendar stays aligned.
+func (f Filter) RankingCalendar(mode string, calendar []DayCalendar) ([]DayCalendar, int) {
+	if !f.HidesRestriction(RankingModeXRestrict(mode)) {
+		return calendar, 0
+	}
+	calendar = slices.Clone(calendar)
+	hidden := 0
+	for i := range calendar {
+		if calendar[i].ImageURL != "" {
+			calendar[i].ImageURL = ""
+			calendar[i].ArtworkLink = ""
+			hidden++
+		}
+	}
+	return calendar, hidden
+}
+
+// MangaSeriesWorks returns the works of a manga series that are not hidden.
+func (f Filter) MangaSeriesWorks(works []MangaSeriesWork) ([]MangaSeriesWork, int) {
+	if f.Empty() {
+		return works, 0
+	}
+	return filterList(works, func(work MangaSeriesWork) bool {
+		return f.HidesArtwork(work.Brief)
+	})
+}
+
+// NovelSeriesContents returns the novels of a novel series that are not hidden.
+func (f Filter) NovelSeriesContents(contents []NovelSeriesContent) ([]NovelSeriesContent, int) {
+	if f.Empty() {
+		return contents, 0
+	}
+	return filterList(contents, func(content NovelSeriesContent) bool {
+		return f.Hides(XRestrict(content.XRestrict), AiType(content.AiType), content.UserID, content.Tags)
+	})
+}
+
+// RecommendedUsers returns the users that are not muted, without their hidden works.
+// The works of muted users are counted as hidden.
+func (f Filter) RecommendedUsers(users []RecommendedUser) ([]RecommendedUser, int) {
+	if f.Empty() {
+		return users, 0
+	}
+	kept := make([]RecommendedUser, 0, len(users))
+	hidden := 0
+	for _, user := range users {
+		if f.Mute.MutesUser(user.ID) {
+			hidden += len(user.Artworks)
+			continue
+		}
+		var n int
+		user.Artworks, n = f.Artworks(user.Artworks)
+		hidden += n
+		kept = append(kept, user)
+	}
+	return kept, hidden
+}
+
+// FollowUsers removes hidden works from the recent works of each user.
+// The users themselves are kept, since following lists should be complete.
+func (f Filter) FollowUsers(users []FollowUser) ([]FollowUser, int) {
+	if f.Empty() {
+		return users, 0
+	}
+	users = slices.Clone(users)
+	hidden := 0
+	for i := range users {
+		var artworks, novels int
+		users[i].Artworks, artworks = f.Artworks(users[i].Artworks)
+		users[i].Novels, novels = f.Novels(users[i].Novels)
+		hidden += artworks + novels
+	}
+	return users, hidden
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	filter := Filter{HideR18G: true, HideAI: true, Mute: MuteList{Tags: []string{"Muted"}, Users: []string{"2"}}}
+
+	artworks := []ArtworkBrief{
+		{ID: "1", ArtistID: "1", Tags: []string{"fine"}},
+		{ID: "2", ArtistID: "2"},
+		{ID: "3", ArtistID: "3", Tags: []string{"fine", "muted"}},
+		{ID: "4", ArtistID: "1", XRestrict: int(R18)},
+		{ID: "5", ArtistID: "1", XRestrict: int(R18G)},
+		{ID: "6", ArtistID: "1", AiType: int(AI)},
+	}
+	kept, hidden := filter.Artworks(artworks)
+	if len(kept) != 2 || kept[0].ID != "1" || kept[1].ID != "4" || hidden != 4 {
+		t.Errorf("unexpected artworks %+v, %d hidden", kept, hidden)
+	}
+	if len(artworks) != 6 || artworks[1].ID != "2" {
+		t.Error("the original slice must not be modified")
+	}
+
+	novels, hidden := filter.Novels([]NovelBrief{{ID: "1", UserID: "2"}, {ID: "2", UserID: "3", Tags: []string{"MUTED"}}, {ID: "3", UserID: "3"}})
+	if len(novels) != 1 || novels[0].ID != "3" || hidden != 2 {
+		t.Errorf("unexpected novels %+v, %d hidden", novels, hidden)
+	}
+
+	var ranking Ranking
+	ranking.Contents = make([]RankingArtwork, 3)
+	for i := range ranking.Contents {
+		ranking.Contents[i].ArtistID = i + 1
+		ranking.Contents[i].Rank = i + 1
+	}
+	ranking, hidden = filter.Ranking(ranking)
+	if len(ranking.Contents) != 2 || ranking.Contents[1].Rank != 3 || hidden != 1 {
+		t.Errorf("unexpected ranking %+v, %d hidden", ranking.Contents, hidden)
+	}
+
+	contents, hidden := filter.NovelSeriesContents([]NovelSeriesContent{{ID: "1", UserID: "1"}, {ID: "2", UserID: "1", XRestrict: int(R18G)}})
+	if len(contents) != 1 || contents[0].ID != "1" || hidden != 1 {
+		t.Errorf("unexpected novel series contents %+v, %d hidden", contents, hidden)
+	}
+
+	users, hidden := filter.RecommendedUsers([]RecommendedUser{
+		{ID: "1", Artworks: artworks[:1]},
+		{ID: "2", Artworks: artworks[1:2]},
+		{ID: "3", Artworks: artworks[2:]},
+	})
+	if len(users) != 2 || len(users[0].Artworks) != 1 || len(users[1].Artworks) != 1 || hidden != 4 {
+		t.Errorf("unexpected users %+v, %d hidden", users, hidden)
+	}
+
+	calendar := []DayCalendar{{DayNumber: 0}, {DayNumber: 1, ImageURL: "a", ArtworkLink: "/artworks/1"}, {DayNumber: 2}}
+	if _, hidden := filter.RankingCalendar("daily_r18", calendar); hidden != 0 {
+		t.Error("R-18 rankings must be kept")
+	}
+	days, hidden := filter.RankingCalendar("r18g", calendar)
+	if len(days) != 3 || days[1].ImageURL != "" || days[1].ArtworkLink != "" || hidden != 1 || calendar[1].ImageURL == "" {
+		t.Errorf("unexpected calendar %+v, %d hidden", days, hidden)
+	}
+
+	if !(Filter{}).Empty() || filter.Empty() || !(Filter{Mute: filter.Mute}).WithoutMutedUsers().Mute.MutesTag("muted") {
+		t.Error("Empty or WithoutMutedUsers is wrong")
+	}
+}
+
+func TestRankingModeXRestrict(t *testing.T) {
+	for mode, want := range map[string]XRestrict{
+		"daily":      Safe,
+		"daily_r18":  R18,
+		"male_r18":   R18,
+		"weekly_r18": R18,
+		"r18g":       R18G,
+	} {
+		if got := RankingModeXRestrict(mode); got != want {
+			t.Errorf("RankingModeXRestrict(%q) = %v, want %v", mode, got, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"net/http"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+	"github.com/goccy/go-json"
+)
+
+// FollowsPerPage is how many users GetUserFollowing and GetUserFollowers return at once
+const FollowsPerPage = 24
+
+// FollowUser is a user in a following or followers list, with their recent works.
+type FollowUser struct {
+	ID         string         `json:"userId"`
+	Name       string         `json:"userName"`
+	Avatar     string         `json:"profileImageUrl"`
+	Comment    string         `json:"userComment"`
+	IsFollowed bool           `json:"following"` // whether the logged in user follows them
+	FollowsYou bool           `json:"followed"`  // whether they follow the logged in user
+	IsMyPixiv  bool           `json:"isMypixiv"`
+	Artworks   []ArtworkBrief `json:"illusts"`
+	Novels     []NovelBrief   `json:"novels"`
+}
+
+// GetUserFollowing returns a page of the users a user follows, and how many they follow in total.
+// rest is "show" for public follows and "hide" for private ones, which only the user themselves can see.
+func GetUserFollowing(r *http.Request, id, rest string, page int) ([]FollowUser, int, error) {
+	return getFollowList(r, GetUserFollowingURL(id, rest, page))
+}
+
+// GetUserFollowers returns a page of the users following a user, and how many there are in total.
+func GetUserFollowers(r *http.Request, id string, page int) ([]FollowUser, int, error) {
+	return getFollowList(r, GetUserFollowersURL(id, page))
+}
+
+func getFollowList(r *http.Request, URL string) ([]FollowUser, int, error) {
+	var body struct {
+		Users []FollowUser `json:"users"`
+		Total int          `json:"total"`
+	}
+
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response = session.ProxyImageUrl(r, response)
+
+	err = json.Unmarshal([]byte(response), &body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return body.Users, body.Total, nil
+}
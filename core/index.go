@@ -28,7 +28,7 @@ type LandingArtworks struct {
 	Recommended     []ArtworkBrief
 	Newest          []ArtworkBrief
 	Rankings        Ranking
-	Users           []ArtworkBrief
+	Users           []RecommendedUser
 	Pixivision      []Pixivision
 	RecommendByTags []RecommendedTags
 }
@@ -46,7 +46,10 @@ func GetLanding(r *http.Request, mode string, isLoggedIn bool) (*LandingArtworks
 			IDs []string `json:"ids"`
 		} `json:"recommend"`
 		// EditorRecommended []any `json:"editorRecommend"`
-		// UserRecommended   []any `json:"recommendUser"`
+		RecommendedUsers []struct {
+			ID        json.Number `json:"id"`
+			IllustIDs []string    `json:"illustIds"`
+		} `json:"recommendUser"`
 		// Commission        []any `json:"completeRequestIds"`
 		RecommendedByTags []struct {
 			Name string   `json:"tag"`
@@ -117,6 +120,19 @@ func GetLanding(r *http.Request, mode string, isLoggedIn bool) (*LandingArtworks
 		for _, i := range pages.Recommended.IDs {
 			landing.Recommended = append(landing.Recommended, artworks[i])
 		}
+
+		var users []recommendedUserInfo
+		err = json.Unmarshal([]byte(gjson.Get(resp, "users").Raw), &users)
+		if err == nil {
+			ids := make([]string, 0, len(pages.RecommendedUsers))
+			illustIDs := make(map[string][]string, len(pages.RecommendedUsers))
+			for _, i := range pages.RecommendedUsers {
+				id := i.ID.String()
+				ids = append(ids, id)
+				illustIDs[id] = i.IllustIDs
+			}
+			landing.Users = buildRecommendedUsers(ids, illustIDs, users, artworks)
+		}
 	}
 
 	// Map the landing mode to the ranking mode
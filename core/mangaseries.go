@@ -2,9 +2,11 @@ package core
 
 import (
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
 	"github.com/goccy/go-json"
 )
@@ -28,19 +30,22 @@ type MangaSeries struct {
 	IsNotifying    bool      `json:"isNotifying"`
 }
 
+// MangaSeriesWork is a work (chapter) of a manga series.
+type MangaSeriesWork struct {
+	WorkID string `json:"workId"`
+	Order  int    `json:"order"`
+	Brief  ArtworkBrief
+}
+
 type MangaSeriesContent struct {
-	Series []struct {
-		WorkID string `json:"workId"`
-		Order  int    `json:"order"`
-		Brief  ArtworkBrief
-	} `json:"series"`
-	IsSetCover           bool   `json:"isSetCover"`
-	SeriesID             int    `json:"seriesId"`
-	OtherSeriesID        string `json:"otherSeriesId"`
-	RecentUpdatedWorkIds []int  `json:"recentUpdatedWorkIds"`
-	Total                int    `json:"total"`
-	IsWatched            bool   `json:"isWatched"`
-	IsNotifying          bool   `json:"isNotifying"`
+	Series               []MangaSeriesWork `json:"series"`
+	IsSetCover           bool              `json:"isSetCover"`
+	SeriesID             int               `json:"seriesId"`
+	OtherSeriesID        string            `json:"otherSeriesId"`
+	RecentUpdatedWorkIds []int             `json:"recentUpdatedWorkIds"`
+	Total                int               `json:"total"`
+	IsWatched            bool              `json:"isWatched"`
+	IsNotifying          bool              `json:"isNotifying"`
 	Brief                MangaSeries
 }
 
@@ -49,7 +54,7 @@ func GetMangaSeriesContentByID(r *http.Request, id string, page int) (MangaSerie
 
 	URL := GetMangaSeriesContentURL(id, page)
 
-	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
 	if err != nil {
 		return series_content, err
 	}
@@ -88,3 +93,34 @@ func GetMangaSeriesContentByID(r *http.Request, id string, page int) (MangaSerie
 
 	return series_content, nil
 }
+
+// maxMangaSeriesPages limits how many pages GetAllMangaSeriesContent requests
+const maxMangaSeriesPages = 100
+
+// GetAllMangaSeriesContent requests every page of a manga series and returns all of its works, in series order.
+// Series with more than maxWorks works are refused before the other pages are requested.
+func GetAllMangaSeriesContent(r *http.Request, id string, maxWorks int) (MangaSeriesContent, error) {
+	content, err := GetMangaSeriesContentByID(r, id, 1)
+	if err != nil {
+		return content, err
+	}
+	if content.Total > maxWorks {
+		return content, i18n.Errorf("Series %s has too many works: %d (at most %d)", id, content.Total, maxWorks)
+	}
+
+	for page := 2; page <= maxMangaSeriesPages && len(content.Series) < content.Total; page++ {
+		next, err := GetMangaSeriesContentByID(r, id, page)
+		if err != nil {
+			return content, err
+		}
+		if len(next.Series) == 0 {
+			break
+		}
+		content.Series = append(content.Series, next.Series...)
+	}
+
+	sort.SliceStable(content.Series, func(i, j int) bool {
+		return content.Series[i].Order < content.Series[j].Order
+	})
+	return content, nil
+}
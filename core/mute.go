@@ -0,0 +1,79 @@
+package core
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+// MuteList holds the tags and users whose works are hidden from listings.
+type MuteList struct {
+	Tags  []string
+	Users []string // user IDs
+}
+
+// GetMuteList returns the mute list saved in the user's cookies.
+func GetMuteList(r *http.Request) MuteList {
+	return MuteList{
+		Tags:  session.GetCookieList(r, session.Cookie_MutedTags),
+		Users: session.GetCookieList(r, session.Cookie_MutedUsers),
+	}
+}
+
+func (m MuteList) Empty() bool {
+	return len(m.Tags) == 0 && len(m.Users) == 0
+}
+
+// MutesUser reports whether a user is muted.
+func (m MuteList) MutesUser(userID string) bool {
+	return slices.Contains(m.Users, userID)
+}
+
+// MutesTag reports whether a tag is muted. Tags are compared case-insensitively.
+func (m MuteList) MutesTag(tag string) bool {
+	return slices.ContainsFunc(m.Tags, func(muted string) bool {
+		return strings.EqualFold(tag, muted)
+	})
+}
+
+// Mutes reports whether a work by userID with these tags is muted.
+func (m MuteList) Mutes(userID string, tags []string) bool {
+	return m.MutesUser(userID) || slices.ContainsFunc(tags, m.MutesTag)
+}
+
+// Kinds of PixivMuteItem
+const (
+	MuteItemTag  = "tag"
+	MuteItemUser = "user"
+)
+
+// PixivMuteItem is an entry of the mute settings of a Pixiv account.
+type PixivMuteItem struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"` // the tag, or the user ID
+	Label   string `json:"label"`
+	Enabled bool   `json:"enabled"` // accounts without Premium can only enable one item
+}
+
+// GetPixivMuteList returns the mute settings of the logged in user's Pixiv account.
+func GetPixivMuteList(r *http.Request) ([]PixivMuteItem, error) {
+	var body struct {
+		Items []PixivMuteItem `json:"mute_items"`
+	}
+
+	response, err := API_GET_UnwrapJson(r.Context(), GetMuteItemsURL(), session.GetUserToken(r))
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(response), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.Items, nil
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestMuteList(t *testing.T) {
+	mute := MuteList{Tags: []string{"Muted"}, Users: []string{"2"}}
+
+	if !mute.Mutes("2", nil) {
+		t.Error("works by muted users must be muted")
+	}
+	if !mute.Mutes("3", []string{"fine", "muted"}) {
+		t.Error("tags must be compared case-insensitively")
+	}
+	if mute.Mutes("1", []string{"fine"}) {
+		t.Error("unexpected muted work")
+	}
+
+	if !(MuteList{}).Empty() || mute.Empty() {
+		t.Error("Empty is wrong")
+	}
+}
@@ -1,10 +1,10 @@
 package core
 
 import (
-	"fmt"
+	"log"
+	"maps"
 	"net/http"
-	"regexp"
-	"strings"
+	"sync"
 	"time"
 
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
@@ -12,30 +12,31 @@ import (
 )
 
 type Novel struct {
-	Bookmarks      int       `json:"bookmarkCount"`
-	CommentCount   int       `json:"commentCount"`
-	MarkerCount    int       `json:"markerCount"`
-	CreateDate     time.Time `json:"createDate"`
-	UploadDate     time.Time `json:"uploadDate"`
-	Description    string    `json:"description"`
-	ID             string    `json:"id"`
-	Title          string    `json:"title"`
-	Likes          int       `json:"likeCount"`
-	Pages          int       `json:"pageCount"`
-	UserID         string    `json:"userId"`
-	UserName       string    `json:"userName"`
-	Views          int       `json:"viewCount"`
-	IsOriginal     bool      `json:"isOriginal"`
-	IsBungei       bool      `json:"isBungei"`
-	XRestrict      int       `json:"xRestrict"`
-	Restrict       int       `json:"restrict"`
-	Content        string    `json:"content"`
-	CoverURL       string    `json:"coverUrl"`
-	IsBookmarkable bool      `json:"isBookmarkable"`
-	BookmarkData   any       `json:"bookmarkData"`
-	LikeData       bool      `json:"likeData"`
-	PollData       any       `json:"pollData"`
-	Marker         any       `json:"marker"`
+	Bookmarks      int          `json:"bookmarkCount"`
+	CommentCount   int          `json:"commentCount"`
+	MarkerCount    int          `json:"markerCount"`
+	CreateDate     time.Time    `json:"createDate"`
+	UploadDate     time.Time    `json:"uploadDate"`
+	Description    string       `json:"description"`
+	ID             string       `json:"id"`
+	Title          string       `json:"title"`
+	Likes          int          `json:"likeCount"`
+	Pages          int          `json:"pageCount"`
+	UserID         string       `json:"userId"`
+	UserName       string       `json:"userName"`
+	Views          int          `json:"viewCount"`
+	IsOriginal     bool         `json:"isOriginal"`
+	IsBungei       bool         `json:"isBungei"`
+	XRestrict      int          `json:"xRestrict"`
+	Restrict       int          `json:"restrict"`
+	Content        string       `json:"content"`
+	CoverURL       string       `json:"coverUrl"`
+	IsBookmarkable bool         `json:"isBookmarkable"`
+	BookmarkData   any          `json:"bookmarkData"`
+	BookmarkID     string       `json:"-"` // ID of the user's bookmark, if they bookmarked the novel
+	LikeData       bool         `json:"likeData"`
+	PollData       *NovelPoll   `json:"pollData"`
+	Marker         *NovelMarker `json:"marker"`
 	Tags           struct {
 		AuthorID string `json:"authorId"`
 		IsLocked bool   `json:"isLocked"`
@@ -93,7 +94,42 @@ type Novel struct {
 		} `json:"urls"`
 	} `json:"textEmbeddedImages"`
 	CommentsList []Comment
-	UserNovels   map[string]*NovelBrief `json:"userNovels"`
+	// CommentsHasNext is set if there are more root comments than CommentsList holds
+	CommentsHasNext bool
+	UserNovels      map[string]*NovelBrief `json:"userNovels"`
+
+	// Document is Content, parsed
+	Document NovelDocument `json:"-"`
+	// EmbeddedIllusts maps the IDs in [pixivimage:] markup (illust ID, optionally followed by -page) to image URLs
+	EmbeddedIllusts map[string]string `json:"-"`
+}
+
+// NovelMarker is where the user left a marker in a novel. It is nil if they haven't.
+type NovelMarker struct {
+	Page int `json:"page"`
+}
+
+// NovelPoll is a poll attached to a novel. It is nil if the novel has none.
+type NovelPoll struct {
+	Question string            `json:"question"`
+	Choices  []NovelPollChoice `json:"choices"`
+	// SelectedValue is the ID of the choice the user voted for, or 0 if they haven't voted
+	SelectedValue int `json:"selectedValue"`
+	Total         int `json:"total"`
+}
+
+type NovelPollChoice struct {
+	ID    int    `json:"id"`
+	Text  string `json:"text"`
+	Count int    `json:"count"`
+}
+
+// Percent returns the share of the votes this choice got, rounded down.
+func (c NovelPollChoice) Percent(total int) int {
+	if total <= 0 {
+		return 0
+	}
+	return c.Count * 100 / total
 }
 
 type NovelBrief struct {
@@ -124,21 +160,79 @@ type NovelBrief struct {
 	Genre          string    `json:"genre"`
 }
 
-// Novel embedded illusts
-var (
-	re_r  = regexp.MustCompile(`\[pixivimage:\d+(-\d+)?\]`)
-	re_d  = regexp.MustCompile(`\d+(-\d+)?`)
-	re_t  = regexp.MustCompile(`\"original\":\"(.+?)\"`)
-	re_u  = regexp.MustCompile(`\[uploadedimage:(\d+)\]`)
-	re_id = regexp.MustCompile(`\d+`)
-)
+// insertIllustsBatchSize is how many illusts are looked up per insert_illusts request
+const insertIllustsBatchSize = 20
+
+// insertedIllust is an entry of the insert_illusts response, keyed by the ID used in [pixivimage:]
+type insertedIllust struct {
+	Illust struct {
+		Images struct {
+			Original string `json:"original"`
+		} `json:"images"`
+	} `json:"illust"`
+}
+
+// getInsertedIllustURLs looks up the image URLs of the illusts inserted in a novel with [pixivimage:].
+// The IDs are requested in batches, concurrently. Illusts that can't be found are missing from the result.
+func getInsertedIllustURLs(r *http.Request, novelID string, ids []string) map[string]string {
+	urls := make(map[string]string, len(ids))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for start := 0; start < len(ids); start += insertIllustsBatchSize {
+		batch := ids[start:min(start+insertIllustsBatchSize, len(ids))]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			URL := GetInsertIllustURL(novelID, batch)
+			response, err := API_GET_UnwrapJson(r.Context(), URL, "")
+			if err != nil {
+				log.Printf("Failed to look up illusts inserted in novel %s: %v", novelID, err)
+				return
+			}
+
+			found, err := parseInsertedIllusts(session.ProxyImageUrl(r, response))
+			if err != nil {
+				log.Printf("Failed to look up illusts inserted in novel %s: %v", novelID, err)
+				return
+			}
+
+			mutex.Lock()
+			maps.Copy(urls, found)
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return urls
+}
+
+// parseInsertedIllusts maps the IDs in an insert_illusts response to the original image URLs.
+func parseInsertedIllusts(response string) (map[string]string, error) {
+	var illusts map[string]*insertedIllust
+	if err := json.Unmarshal([]byte(response), &illusts); err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]string, len(illusts))
+	for id, illust := range illusts {
+		// illusts that were deleted or made private come back as null, or without images
+		if illust != nil && illust.Illust.Images.Original != "" {
+			urls[id] = illust.Illust.Images.Original
+		}
+	}
+	return urls, nil
+}
 
 func GetNovelByID(r *http.Request, id string) (Novel, error) {
 	var novel Novel
 
 	URL := GetNovelURL(id)
 
-	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
+	// the user's token, so that bookmark, like and marker data are theirs
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
 	if err != nil {
 		return novel, err
 	}
@@ -149,6 +243,10 @@ func GetNovelByID(r *http.Request, id string) (Novel, error) {
 		return novel, err
 	}
 
+	if bookmark, ok := novel.BookmarkData.(map[string]any); ok {
+		novel.BookmarkID, _ = bookmark["id"].(string)
+	}
+
 	// Clean up UserNovels map by removing null entries
 	if novel.UserNovels != nil {
 		cleanedUserNovels := make(map[string]*NovelBrief)
@@ -163,29 +261,29 @@ func GetNovelByID(r *http.Request, id string) (Novel, error) {
 	// Debug logging
 	// fmt.Printf("UserNovels populated with %d entries after cleanup\n", len(novel.UserNovels))
 
-	novel.Content = re_r.ReplaceAllStringFunc(novel.Content, func(s string) string {
-		illustid := re_d.FindString(s)
-
-		URL := GetInsertIllustURL(novel.ID, illustid)
-		response, err := API_GET_UnwrapJson(r.Context(), URL, "")
-		if err != nil {
-			return "Cannot insert illust" + illustid
+	novel.Document = ParseNovelContent(novel.Content)
+	// collect the inserted illusts first, to look them all up at once
+	var illustIDs []string
+	seen := make(map[string]bool)
+	novel.Document.eachNode(func(node *NovelNode) {
+		if node.Kind == NovelNodeImage && node.ImageSource == "pixivimage" && !seen[node.ImageID] {
+			seen[node.ImageID] = true
+			illustIDs = append(illustIDs, node.ImageID)
 		}
-
-		url := re_t.FindString(response)
-		url = session.ProxyImageUrl(r, url[11:]) // truncate the "original":
-
-		// make [pixivimage:illustid-index] jump to anchor
-		link := fmt.Sprintf("/artworks/%s", strings.ReplaceAll(illustid, "-", "#"))
-		return fmt.Sprintf(`<a href="%s" target="_blank"><img src=%s alt="%s"/></a>`, link, url, s)
 	})
+	novel.EmbeddedIllusts = getInsertedIllustURLs(r, novel.ID, illustIDs)
 
-	novel.Content = re_u.ReplaceAllStringFunc(novel.Content, func(s string) string {
-		imageId := re_id.FindString(s)
-		if val, ok := novel.TextEmbeddedImages[imageId]; ok {
-			return fmt.Sprintf(`<img src=%s alt="%s"/>`, val.Urls.Original, s)
+	// images that can't be found keep an empty URL, and are shown as placeholders
+	novel.Document.eachNode(func(node *NovelNode) {
+		if node.Kind != NovelNodeImage {
+			return
+		}
+		switch node.ImageSource {
+		case "pixivimage":
+			node.URL = novel.EmbeddedIllusts[node.ImageID]
+		case "uploadedimage":
+			node.URL = novel.TextEmbeddedImages[node.ImageID].Urls.Original
 		}
-		return s
 	})
 
 	return novel, nil
@@ -212,24 +310,3 @@ func GetNovelRelated(r *http.Request, id string) ([]NovelBrief, error) {
 
 	return novels.List, nil
 }
-
-func GetNovelComments(r *http.Request, id string) ([]Comment, error) {
-	var body struct {
-		Comments []Comment `json:"comments"`
-	}
-
-	URL := GetNovelCommentsURL(id)
-
-	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
-	if err != nil {
-		return nil, err
-	}
-	response = session.ProxyImageUrl(r, response)
-
-	err = json.Unmarshal([]byte(response), &body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body.Comments, nil
-}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Pixiv novel markup, see https://www.pixiv.help/hc/en-us/articles/235584628
+//
+//	[newpage]                   starts a new page
+//	[chapter:title]             starts a chapter. the title can contain ruby
+//	[[rb:漢字 > かんじ]]         ruby (furigana)
+//	[jump:N]                    link to page N
+//	[[jumpuri:text > url]]      link to an external URL
+//	[pixivimage:ID] or [pixivimage:ID-page]  an illustration on Pixiv
+//	[uploadedimage:ID]          an image uploaded with the novel
+
+// Kinds of NovelNode
+const (
+	NovelNodeText  = "text"
+	NovelNodeRuby  = "ruby"
+	NovelNodeLink  = "link"
+	NovelNodeJump  = "jump"
+	NovelNodeImage = "image"
+)
+
+// Kinds of NovelBlock
+const (
+	NovelBlockParagraph = "paragraph"
+	NovelBlockChapter   = "chapter"
+)
+
+// NovelNode is an inline element of a novel.
+type NovelNode struct {
+	Kind string
+	Text string // text, ruby base or link text
+	Ruby string // reading of a ruby node
+	URL  string // link target (only http and https are kept), or image URL once resolved
+	Page int    // target page of a jump node
+
+	// images only
+	ImageSource string // "pixivimage" or "uploadedimage"
+	ImageID     string // illust ID (optionally followed by -page) or uploaded image ID
+	Link        string // where the image links to, if anywhere
+	Markup      string // the original markup
+}
+
+// NovelBlock is a line of text, or a chapter heading.
+type NovelBlock struct {
+	Kind    string
+	Nodes   []NovelNode // the content of a paragraph, or the title of a chapter. an empty paragraph is a blank line
+	Chapter int         // chapters only: the chapter number, starting at 1
+	Title   string      // chapters only: the title as plain text
+}
+
+type NovelPage struct {
+	Number int // starting at 1
+	Blocks []NovelBlock
+}
+
+type NovelChapter struct {
+	Number int
+	Title  string
+	Page   int
+}
+
+// NovelDocument is the parsed content of a novel.
+type NovelDocument struct {
+	Pages    []NovelPage
+	Chapters []NovelChapter
+}
+
+type novelTokenKind int
+
+const (
+	tokenText novelTokenKind = iota
+	tokenNewline
+	tokenNewPage
+	tokenChapter
+	tokenRuby
+	tokenLink
+	tokenJump
+	tokenPixivImage
+	tokenUploadedImage
+)
+
+type novelToken struct {
+	kind novelTokenKind
+	text string // text, chapter title, ruby base, link text or image ID
+	arg  string // ruby reading, link URL or jump target
+	raw  string // the markup as written
+}
+
+// tokenizeNovel splits novel content into text, newlines and markup.
+// Anything that looks like markup but isn't valid is kept as text.
+func tokenizeNovel(content string) []novelToken {
+	var tokens []novelToken
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			tokens = append(tokens, novelToken{kind: tokenText, text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(content); {
+		switch content[i] {
+		case '\n':
+			flush()
+			tokens = append(tokens, novelToken{kind: tokenNewline})
+			i++
+			continue
+		case '\r':
+			i++
+			continue
+		case '[':
+			if token, n, ok := scanNovelTag(content[i:]); ok {
+				flush()
+				tokens = append(tokens, token)
+				i += n
+				continue
+			}
+		}
+		text.WriteByte(content[i])
+		i++
+	}
+	flush()
+	return tokens
+}
+
+// scanNovelTag reads a markup tag at the start of s, returning the token and its length.
+func scanNovelTag(s string) (novelToken, int, bool) {
+	line := s
+	if end := strings.IndexByte(s, '\n'); end >= 0 {
+		line = s[:end]
+	}
+
+	if strings.HasPrefix(line, "[[") {
+		end := strings.Index(line, "]]")
+		if end < 0 {
+			return novelToken{}, 0, false
+		}
+		inner, raw := line[2:end], line[:end+2]
+
+		if body, ok := strings.CutPrefix(inner, "rb:"); ok {
+			base, reading, found := strings.Cut(body, ">")
+			base, reading = strings.TrimSpace(base), strings.TrimSpace(reading)
+			if found && base != "" && reading != "" {
+				return novelToken{kind: tokenRuby, text: base, arg: reading, raw: raw}, len(raw), true
+			}
+		}
+		if body, ok := strings.CutPrefix(inner, "jumpuri:"); ok {
+			// the URL comes last and doesn't contain '>', while the text might
+			if sep := strings.LastIndexByte(body, '>'); sep >= 0 {
+				text, url := strings.TrimSpace(body[:sep]), strings.TrimSpace(body[sep+1:])
+				if text != "" && url != "" {
+					return novelToken{kind: tokenLink, text: text, arg: url, raw: raw}, len(raw), true
+				}
+			}
+		}
+		return novelToken{}, 0, false
+	}
+
+	// chapter titles can contain ruby, so the tag ends at the matching bracket
+	end := strings.IndexByte(line, ']')
+	if strings.HasPrefix(line, "[chapter:") {
+		end = matchingBracket(line)
+	}
+	if end < 0 {
+		return novelToken{}, 0, false
+	}
+	inner, raw := line[1:end], line[:end+1]
+
+	switch {
+	case inner == "newpage":
+		return novelToken{kind: tokenNewPage, raw: raw}, len(raw), true
+	case strings.HasPrefix(inner, "chapter:"):
+		if title := strings.TrimSpace(inner[len("chapter:"):]); title != "" {
+			return novelToken{kind: tokenChapter, text: title, raw: raw}, len(raw), true
+		}
+	case strings.HasPrefix(inner, "jump:"):
+		if target := strings.TrimSpace(inner[len("jump:"):]); isDigits(target) {
+			return novelToken{kind: tokenJump, arg: target, raw: raw}, len(raw), true
+		}
+	case strings.HasPrefix(inner, "pixivimage:"):
+		id := inner[len("pixivimage:"):]
+		illust, page, hasPage := strings.Cut(id, "-")
+		if isDigits(illust) && (!hasPage || isDigits(page)) {
+			return novelToken{kind: tokenPixivImage, text: id, raw: raw}, len(raw), true
+		}
+	case strings.HasPrefix(inner, "uploadedimage:"):
+		if id := inner[len("uploadedimage:"):]; isDigits(id) {
+			return novelToken{kind: tokenUploadedImage, text: id, raw: raw}, len(raw), true
+		}
+	}
+	return novelToken{}, 0, false
+}
+
+// matchingBracket returns the index of the ']' closing the '[' that s starts with, or -1.
+func matchingBracket(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseChapterTitle parses the markup in a chapter title, returning its nodes and its plain text.
+// Only ruby is supported; other markup is kept as text.
+func parseChapterTitle(title string) ([]NovelNode, string) {
+	var nodes []NovelNode
+	var plain strings.Builder
+	addText := func(text string) {
+		plain.WriteString(text)
+		if n := len(nodes); n > 0 && nodes[n-1].Kind == NovelNodeText {
+			nodes[n-1].Text += text
+			return
+		}
+		nodes = append(nodes, NovelNode{Kind: NovelNodeText, Text: text})
+	}
+
+	for _, token := range tokenizeNovel(title) {
+		switch token.kind {
+		case tokenText:
+			addText(token.text)
+		case tokenRuby:
+			plain.WriteString(token.text)
+			nodes = append(nodes, NovelNode{Kind: NovelNodeRuby, Text: token.text, Ruby: token.arg})
+		default:
+			addText(token.raw)
+		}
+	}
+	return nodes, plain.String()
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseNovelContent parses novel markup into pages of paragraphs and chapter headings.
+//
+// Every line becomes a paragraph. Lines that only hold [newpage] or [chapter:] don't produce empty paragraphs.
+// Image URLs are not resolved; see GetNovelByID.
+func ParseNovelContent(content string) NovelDocument {
+	p := novelParser{}
+	p.doc.Pages = []NovelPage{{Number: 1}}
+
+	for _, token := range tokenizeNovel(content) {
+		switch token.kind {
+		case tokenNewline:
+			p.endLine()
+		case tokenNewPage:
+			p.flushParagraph()
+			p.doc.Pages = append(p.doc.Pages, NovelPage{Number: len(p.doc.Pages) + 1})
+			p.lineHasBlock = true
+		case tokenChapter:
+			p.flushParagraph()
+			nodes, title := parseChapterTitle(token.text)
+			chapter := NovelChapter{Number: len(p.doc.Chapters) + 1, Title: title, Page: len(p.doc.Pages)}
+			p.doc.Chapters = append(p.doc.Chapters, chapter)
+			p.addBlock(NovelBlock{Kind: NovelBlockChapter, Chapter: chapter.Number, Title: chapter.Title, Nodes: nodes})
+			p.lineHasBlock = true
+		case tokenText:
+			p.paragraph = append(p.paragraph, NovelNode{Kind: NovelNodeText, Text: token.text})
+		case tokenRuby:
+			p.paragraph = append(p.paragraph, NovelNode{Kind: NovelNodeRuby, Text: token.text, Ruby: token.arg})
+		case tokenLink:
+			node := NovelNode{Kind: NovelNodeLink, Text: token.text}
+			if strings.HasPrefix(token.arg, "https://") || strings.HasPrefix(token.arg, "http://") {
+				node.URL = token.arg
+			}
+			p.paragraph = append(p.paragraph, node)
+		case tokenJump:
+			page, _ := strconv.Atoi(token.arg)
+			p.paragraph = append(p.paragraph, NovelNode{Kind: NovelNodeJump, Page: page, Markup: token.raw})
+		case tokenPixivImage:
+			p.paragraph = append(p.paragraph, NovelNode{
+				Kind:        NovelNodeImage,
+				ImageSource: "pixivimage",
+				ImageID:     token.text,
+				Link:        "/artworks/" + strings.Replace(token.text, "-", "#", 1),
+				Markup:      token.raw,
+			})
+		case tokenUploadedImage:
+			p.paragraph = append(p.paragraph, NovelNode{Kind: NovelNodeImage, ImageSource: "uploadedimage", ImageID: token.text, Markup: token.raw})
+		}
+	}
+	if len(p.paragraph) > 0 {
+		p.endLine()
+	}
+
+	// jumps to pages that don't exist are shown as they were written
+	p.doc.eachNode(func(node *NovelNode) {
+		if node.Kind == NovelNodeJump && (node.Page < 1 || node.Page > len(p.doc.Pages)) {
+			*node = NovelNode{Kind: NovelNodeText, Text: node.Markup}
+		}
+	})
+	return p.doc
+}
+
+type novelParser struct {
+	doc       NovelDocument
+	paragraph []NovelNode
+	// lineHasBlock is set once the current line held a page break or a chapter heading
+	lineHasBlock bool
+}
+
+func (p *novelParser) addBlock(block NovelBlock) {
+	page := &p.doc.Pages[len(p.doc.Pages)-1]
+	page.Blocks = append(page.Blocks, block)
+}
+
+// flushParagraph ends the paragraph in the middle of a line, dropping it if it is only whitespace.
+func (p *novelParser) flushParagraph() {
+	if !isBlankParagraph(p.paragraph) {
+		p.addBlock(NovelBlock{Kind: NovelBlockParagraph, Nodes: p.paragraph})
+	}
+	p.paragraph = nil
+}
+
+func (p *novelParser) endLine() {
+	switch {
+	case p.lineHasBlock:
+		p.flushParagraph()
+	case isBlankParagraph(p.paragraph):
+		// a blank line, which authors use to separate scenes
+		p.addBlock(NovelBlock{Kind: NovelBlockParagraph})
+	default:
+		p.addBlock(NovelBlock{Kind: NovelBlockParagraph, Nodes: p.paragraph})
+	}
+	p.paragraph = nil
+	p.lineHasBlock = false
+}
+
+func isBlankParagraph(nodes []NovelNode) bool {
+	for _, node := range nodes {
+		if node.Kind != NovelNodeText || strings.TrimSpace(node.Text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// eachNode calls fn with every inline node of the document.
+func (d *NovelDocument) eachNode(fn func(node *NovelNode)) {
+	for i := range d.Pages {
+		for j := range d.Pages[i].Blocks {
+			nodes := d.Pages[i].Blocks[j].Nodes
+			for k := range nodes {
+				fn(&nodes[k])
+			}
+		}
+	}
+}
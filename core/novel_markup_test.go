@@ -0,0 +1,93 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func novelText(s string) NovelNode {
+	return NovelNode{Kind: NovelNodeText, Text: s}
+}
+
+func novelParagraph(nodes ...NovelNode) NovelBlock {
+	return NovelBlock{Kind: NovelBlockParagraph, Nodes: nodes}
+}
+
+func TestParseNovelContent(t *testing.T) {
+	content := "[chapter:Start]\n" +
+		"A [[rb:漢字 > かんじ]] word\r\n" +
+		"\n" +
+		"[pixivimage:123-2][uploadedimage:45]\n" +
+		"[newpage]\n" +
+		"[chapter: Second ]See [jump:1], [jump:9] and [[jumpuri:a > b > https://example.com]] [[jumpuri:x > javascript:alert(1)]]\n" +
+		"[[rb: > x]] [chapter:] [pixivimage:12a] [newpage"
+
+	doc := ParseNovelContent(content)
+
+	if len(doc.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(doc.Pages))
+	}
+	wantChapters := []NovelChapter{{Number: 1, Title: "Start", Page: 1}, {Number: 2, Title: "Second", Page: 2}}
+	if !reflect.DeepEqual(doc.Chapters, wantChapters) {
+		t.Errorf("chapters = %+v, want %+v", doc.Chapters, wantChapters)
+	}
+
+	wantFirst := []NovelBlock{
+		{Kind: NovelBlockChapter, Chapter: 1, Title: "Start", Nodes: []NovelNode{novelText("Start")}},
+		novelParagraph(novelText("A "), NovelNode{Kind: NovelNodeRuby, Text: "漢字", Ruby: "かんじ"}, novelText(" word")),
+		{Kind: NovelBlockParagraph},
+		novelParagraph(
+			NovelNode{Kind: NovelNodeImage, ImageSource: "pixivimage", ImageID: "123-2", Link: "/artworks/123#2", Markup: "[pixivimage:123-2]"},
+			NovelNode{Kind: NovelNodeImage, ImageSource: "uploadedimage", ImageID: "45", Markup: "[uploadedimage:45]"},
+		),
+	}
+	if !reflect.DeepEqual(doc.Pages[0].Blocks, wantFirst) {
+		t.Errorf("page 1 = %+v\nwant %+v", doc.Pages[0].Blocks, wantFirst)
+	}
+
+	wantSecond := []NovelBlock{
+		{Kind: NovelBlockChapter, Chapter: 2, Title: "Second", Nodes: []NovelNode{novelText("Second")}},
+		novelParagraph(
+			novelText("See "),
+			NovelNode{Kind: NovelNodeJump, Page: 1, Markup: "[jump:1]"},
+			novelText(", "),
+			novelText("[jump:9]"),
+			novelText(" and "),
+			NovelNode{Kind: NovelNodeLink, Text: "a > b", URL: "https://example.com"},
+			novelText(" "),
+			NovelNode{Kind: NovelNodeLink, Text: "x"},
+		),
+		novelParagraph(novelText("[[rb: > x]] [chapter:] [pixivimage:12a] [newpage")),
+	}
+	if !reflect.DeepEqual(doc.Pages[1].Blocks, wantSecond) {
+		t.Errorf("page 2 = %+v\nwant %+v", doc.Pages[1].Blocks, wantSecond)
+	}
+}
+
+func TestParseNovelChapterRuby(t *testing.T) {
+	doc := ParseNovelContent("[chapter:[[rb:漢字 > かんじ]]の章 [jump:1]]\n[chapter:[[rb:a > b]]")
+
+	wantChapters := []NovelChapter{{Number: 1, Title: "漢字の章 [jump:1]", Page: 1}}
+	if !reflect.DeepEqual(doc.Chapters, wantChapters) {
+		t.Errorf("chapters = %+v, want %+v", doc.Chapters, wantChapters)
+	}
+
+	want := []NovelBlock{
+		{Kind: NovelBlockChapter, Chapter: 1, Title: "漢字の章 [jump:1]", Nodes: []NovelNode{
+			{Kind: NovelNodeRuby, Text: "漢字", Ruby: "かんじ"},
+			novelText("の章 [jump:1]"),
+		}},
+		// unbalanced brackets aren't a chapter
+		novelParagraph(novelText("[chapter:"), NovelNode{Kind: NovelNodeRuby, Text: "a", Ruby: "b"}),
+	}
+	if !reflect.DeepEqual(doc.Pages[0].Blocks, want) {
+		t.Errorf("blocks = %+v\nwant %+v", doc.Pages[0].Blocks, want)
+	}
+}
+
+func TestParseNovelContentEmpty(t *testing.T) {
+	doc := ParseNovelContent("")
+	if len(doc.Pages) != 1 || len(doc.Pages[0].Blocks) != 0 || len(doc.Chapters) != 0 {
+		t.Errorf("unexpected document for empty content: %+v", doc)
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGetInsertIllustURL(t *testing.T) {
+	got, err := url.Parse(GetInsertIllustURL("10", []string{"1", "2-3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "/ajax/novel/10/insert_illusts" {
+		t.Errorf("unexpected path %q", got.Path)
+	}
+	if ids := got.Query()["id[]"]; !reflect.DeepEqual(ids, []string{"1", "2-3"}) {
+		t.Errorf("id[] = %v", ids)
+	}
+}
+
+func TestParseInsertedIllusts(t *testing.T) {
+	response := `{
+		"1": {"visible": true, "illust": {"title": "a", "images": {"small": "s", "medium": "m", "original": "/proxy/i.pximg.net/img-original/1_p0.png"}}},
+		"2-3": {"visible": true, "illust": {"title": "b", "images": {"original": "/proxy/i.pximg.net/img-original/2_p2.jpg"}}},
+		"4": {"visible": false, "illust": {"images": {"original": null}}},
+		"5": null
+	}`
+
+	urls, err := parseInsertedIllusts(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"1":   "/proxy/i.pximg.net/img-original/1_p0.png",
+		"2-3": "/proxy/i.pximg.net/img-original/2_p2.jpg",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+
+	if _, err := parseInsertedIllusts(`[]`); err == nil {
+		t.Error("expected an error for an unexpected response")
+	}
+}
+
+func TestNovelPollChoicePercent(t *testing.T) {
+	choice := NovelPollChoice{Count: 2}
+	if got := choice.Percent(3); got != 66 {
+		t.Errorf("Percent(3) = %d, want 66", got)
+	}
+	if got := choice.Percent(0); got != 0 {
+		t.Errorf("Percent(0) = %d, want 0", got)
+	}
+}
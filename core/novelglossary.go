@@ -0,0 +1,58 @@
+package core
+
+import (
+	"net/http"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+	"github.com/goccy/go-json"
+)
+
+// NovelGlossary lists the characters, places and terms an author wrote down for a novel series.
+type NovelGlossary struct {
+	AuthorUserID string                  `json:"authorUserId"`
+	Categories   []NovelGlossaryCategory `json:"categories"`
+}
+
+type NovelGlossaryCategory struct {
+	ID    string              `json:"id"`
+	Name  string              `json:"name"`
+	Items []NovelGlossaryItem `json:"items"`
+}
+
+type NovelGlossaryItem struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	PhoneticName string `json:"phoneticName"`
+	Overview     string `json:"overview"`
+	CoverImage   string `json:"coverImage"`
+}
+
+// Empty reports whether the glossary has nothing to show.
+func (g NovelGlossary) Empty() bool {
+	for _, category := range g.Categories {
+		if len(category.Items) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func GetNovelSeriesGlossary(r *http.Request, seriesID string) (NovelGlossary, error) {
+	var glossary NovelGlossary
+
+	URL := GetNovelSeriesGlossaryURL(seriesID)
+
+	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
+	if err != nil {
+		return glossary, err
+	}
+
+	response = session.ProxyImageUrl(r, response)
+
+	err = json.Unmarshal([]byte(response), &glossary)
+	if err != nil {
+		return glossary, err
+	}
+
+	return glossary, nil
+}
@@ -98,7 +98,7 @@ func GetNovelSeriesByID(r *http.Request, id string) (NovelSeries, error) {
 
 	URL := GetNovelSeriesURL(id)
 
-	response, err := API_GET_UnwrapJson(r.Context(), URL, "")
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
 	if err != nil {
 		return series, err
 	}
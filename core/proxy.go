@@ -0,0 +1,127 @@
+package core
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+// ProxyRequestHeaders are the client request headers forwarded upstream by ProxyRequest,
+// so that browsers can resume downloads and revalidate cached media.
+var ProxyRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// ProxyResponseHeaders are the upstream response headers copied back to the client.
+// Everything else (Set-Cookie, Server, etc.) is dropped.
+var ProxyResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"Cache-Control",
+	"Expires",
+	"Last-Modified",
+	"ETag",
+}
+
+// ForwardProxyHeaders copies ProxyRequestHeaders from the client request r to the upstream request req.
+func ForwardProxyHeaders(r *http.Request, req *http.Request) {
+	for _, name := range ProxyRequestHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+}
+
+// CheckProxyResponse verifies that a successful upstream response is media we are willing to serve:
+// an image or a video no larger than PIXIVFE_PROXY_MAX_BODY_SIZE.
+func CheckProxyResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "video/") {
+		return i18n.Errorf("upstream returned an unexpected content type: %q", contentType)
+	}
+
+	if maxSize := proxyMaxBodySize(); maxSize > 0 && resp.ContentLength > maxSize {
+		return i18n.Errorf("upstream response is too large: %d bytes", resp.ContentLength)
+	}
+	return nil
+}
+
+// CopyProxyResponse writes an upstream response to the client.
+//
+// Only ProxyResponseHeaders are copied. 200, 206 and 304 responses are passed through;
+// the body of any other response is replaced with a plain text status, since it could be an arbitrary page from upstream.
+// The body is cut off after PIXIVFE_PROXY_MAX_BODY_SIZE bytes.
+func CopyProxyResponse(w http.ResponseWriter, resp *http.Response, body io.Reader) error {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+	default:
+		http.Error(w, http.StatusText(resp.StatusCode), resp.StatusCode)
+		return nil
+	}
+
+	header := w.Header()
+	for _, name := range ProxyResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	maxSize := proxyMaxBodySize()
+	if maxSize <= 0 {
+		if _, err := io.Copy(w, body); err != nil {
+			return i18n.Errorf("failed to copy response body: %w", err)
+		}
+		return nil
+	}
+
+	n, err := io.Copy(w, io.LimitReader(body, maxSize))
+	if err != nil {
+		return i18n.Errorf("failed to copy response body: %w", err)
+	}
+	if n == maxSize && hasMore(body) {
+		// the headers are already sent, so all we can do is to stop
+		log.Printf("Proxied response from %s exceeded the maximum body size, cut off", resp.Request.URL)
+	}
+	return nil
+}
+
+// ProxyRequest forwards an HTTP request to the target server and copies the response back.
+//
+// r is the client request; its Range and conditional headers are forwarded, so that 206 and 304 responses work.
+func ProxyRequest(w http.ResponseWriter, r *http.Request, req *http.Request) error {
+	ForwardProxyHeaders(r, req)
+
+	resp, err := utils.HttpClient.Do(req)
+	if err != nil {
+		return i18n.Errorf("failed to proxy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := CheckProxyResponse(resp); err != nil {
+		return err
+	}
+	return CopyProxyResponse(w, resp, resp.Body)
+}
+
+func hasMore(body io.Reader) bool {
+	n, _ := body.Read(make([]byte, 1))
+	return n > 0
+}
+
+func proxyMaxBodySize() int64 {
+	return int64(config.GlobalConfig.ProxyMaxBodySize) << 20
+}
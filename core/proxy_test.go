@@ -0,0 +1,88 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+)
+
+func TestProxyRequest(t *testing.T) {
+	config.GlobalConfig.ProxyMaxBodySize = 1
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "secret=1")
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("ETag", `"abc"`)
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			if r.Header.Get("If-None-Match") == `"abc"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if r.Header.Get("Range") == "bytes=0-1" {
+				w.Header().Set("Content-Range", "bytes 0-1/4")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("ab"))
+				return
+			}
+			w.Write([]byte("abcd"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<script>alert(1)</script>"))
+		case "/missing.png":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<script>alert(1)</script>"))
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", "2097152")
+			w.Write(make([]byte, 2<<20))
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := func(path string, header http.Header) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest("GET", "/proxy"+path, nil)
+		r.Header = header
+		req, _ := http.NewRequest("GET", upstream.URL+path, nil)
+		w := httptest.NewRecorder()
+		return w, ProxyRequest(w, r, req)
+	}
+
+	w, err := proxy("/image.png", http.Header{})
+	if err != nil || w.Code != http.StatusOK || w.Body.String() != "abcd" {
+		t.Errorf("Expected image to be proxied, got %d %q (%v)", w.Code, w.Body.String(), err)
+	}
+	if w.Header().Get("Set-Cookie") != "" || w.Header().Get("Server") != "" {
+		t.Errorf("Expected upstream headers to be dropped, got %v", w.Header())
+	}
+	if w.Header().Get("ETag") != `"abc"` {
+		t.Errorf("Expected ETag to be kept, got %v", w.Header())
+	}
+
+	w, _ = proxy("/image.png", http.Header{"Range": {"bytes=0-1"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "ab" || w.Header().Get("Content-Range") != "bytes 0-1/4" {
+		t.Errorf("Expected partial content, got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w, _ = proxy("/image.png", http.Header{"If-None-Match": {`"abc"`}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected not modified, got %d %q", w.Code, w.Body.String())
+	}
+
+	if _, err := proxy("/page.html", http.Header{}); err == nil {
+		t.Errorf("Expected HTML to be rejected")
+	}
+
+	w, _ = proxy("/missing.png", http.Header{})
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") == "text/html" {
+		t.Errorf("Expected upstream error page to be replaced, got %d %v", w.Code, w.Header())
+	}
+
+	if _, err := proxy("/large.png", http.Header{}); err == nil {
+		t.Errorf("Expected large response to be rejected")
+	}
+}
@@ -27,19 +27,23 @@ const (
 	retryDelay = 10 * time.Millisecond
 )
 
+// RankingArtwork is an entry of a ranking.
+type RankingArtwork struct {
+	Title        string   `json:"title"`
+	Thumbnail    string   `json:"url"`
+	Pages        int      `json:"illust_page_count,string"`
+	ArtistName   string   `json:"user_name"`
+	ArtistAvatar string   `json:"profile_img"`
+	ID           int      `json:"illust_id"`
+	ArtistID     int      `json:"user_id"`
+	Rank         int      `json:"rank"`
+	IllustType   int      `json:"illust_type,string"`
+	Tags         []string `json:"tags"`
+	XRestrict    int      // set from the ranking mode, since Pixiv does not send it
+}
+
 type Ranking struct {
-	Contents []struct {
-		Title        string `json:"title"`
-		Thumbnail    string `json:"url"`
-		Pages        int    `json:"illust_page_count,string"`
-		ArtistName   string `json:"user_name"`
-		ArtistAvatar string `json:"profile_img"`
-		ID           int    `json:"illust_id"`
-		ArtistID     int    `json:"user_id"`
-		Rank         int    `json:"rank"`
-		IllustType   int    `json:"illust_type,string"`
-		XRestrict    int    // zero field for template
-	} `json:"contents"`
+	Contents []RankingArtwork `json:"contents"`
 
 	Mode        string          `json:"mode"`
 	Content     string          `json:"content"`
@@ -52,6 +56,19 @@ type Ranking struct {
 	NextDate    string
 }
 
+// RankingModeXRestrict returns the age restriction of the works in a ranking mode,
+// such as R18 for "daily_r18" and R18G for "r18g".
+func RankingModeXRestrict(mode string) XRestrict {
+	switch {
+	case mode == "r18g":
+		return R18G
+	case strings.HasSuffix(mode, "_r18"):
+		return R18
+	default:
+		return Safe
+	}
+}
+
 func GetRanking(r *http.Request, mode, content, date, page string) (Ranking, error) {
 	// log.Printf("GetRanking called with mode: %s, content: %s, date: %s, page: %s", mode, content, date, page)
 
@@ -97,6 +114,11 @@ func GetRanking(r *http.Request, mode, content, date, page string) (Ranking, err
 	ranking.PrevDate = strings.ReplaceAll(string(ranking.PrevDateRaw[:]), "\"", "")
 	ranking.NextDate = strings.ReplaceAll(string(ranking.NextDateRaw[:]), "\"", "")
 
+	xRestrict := int(RankingModeXRestrict(mode))
+	for i := range ranking.Contents {
+		ranking.Contents[i].XRestrict = xRestrict
+	}
+
 	// log.Printf("GetRanking completed successfully. PrevDate: %s, NextDate: %s", ranking.PrevDate, ranking.NextDate)
 
 	return ranking, nil
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+type UgoiraFrame struct {
+	File  string `json:"file"`
+	Delay int    `json:"delay"` // in milliseconds
+}
+
+// UgoiraMeta describes the frames of an ugoira.
+//
+// Src and OriginalSrc point to ZIP archives on i.pximg.net containing one image per frame.
+// Src contains frames downscaled to fit 600x600, OriginalSrc the frames at their original resolution.
+// The URLs are not proxied, since they are only fetched by the server.
+type UgoiraMeta struct {
+	Src         string        `json:"src"`
+	OriginalSrc string        `json:"originalSrc"`
+	MimeType    string        `json:"mime_type"`
+	Frames      []UgoiraFrame `json:"frames"`
+}
+
+func GetUgoiraMeta(r *http.Request, id string) (UgoiraMeta, error) {
+	var meta UgoiraMeta
+
+	URL := GetUgoiraMetaURL(id)
+
+	response, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
+	if err != nil {
+		return meta, err
+	}
+
+	err = json.Unmarshal([]byte(response), &meta)
+	if err != nil {
+		return meta, err
+	}
+
+	if len(meta.Frames) == 0 {
+		return meta, i18n.Errorf("Ugoira %s has no frames", id)
+	}
+
+	return meta, nil
+}
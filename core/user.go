@@ -382,7 +382,7 @@ func GetUserProfile(r *http.Request, id string, category UserArtCategory, page i
 	fmt.Printf("user.CountInfo.Novels set to: %d\n", user.CountInfo.Novels)
 
 	// Get bookmarks count
-	_, bookmarksCount, err := GetUserBookmarks(r, id, "show", 1)
+	_, bookmarksCount, err := GetUserBookmarks(r, id, "show", "", 1)
 	if err != nil {
 		return user, err
 	}
@@ -390,7 +390,7 @@ func GetUserProfile(r *http.Request, id string, category UserArtCategory, page i
 
 	if category == UserArt_Bookmarks {
 		// Bookmarks
-		works, _, err := GetUserBookmarks(r, id, "show", page)
+		works, _, err := GetUserBookmarks(r, id, "show", "", page)
 		if err != nil {
 			return user, err
 		}
@@ -448,12 +448,18 @@ func GetUserProfile(r *http.Request, id string, category UserArtCategory, page i
 	return user, nil
 }
 
-func GetUserBookmarks(r *http.Request, id, mode string, page int) ([]ArtworkBrief, int, error) {
+// BookmarksPerPage is how many bookmarks GetUserBookmarks returns at once
+const BookmarksPerPage = 48
+
+// GetUserBookmarks returns a page of a user's bookmarks and their total count.
+// mode is "show" for public bookmarks and "hide" for private ones, which only the user themselves can see.
+// Only bookmarks with the given tag are listed, unless it is empty.
+func GetUserBookmarks(r *http.Request, id, mode, tag string, page int) ([]ArtworkBrief, int, error) {
 	page--
 
-	URL := GetUserBookmarksURL(id, mode, page)
+	URL := GetUserBookmarksURL(id, mode, tag, page)
 
-	resp, err := API_GET_UnwrapJson(r.Context(), URL, "")
+	resp, err := API_GET_UnwrapJson(r.Context(), URL, session.GetUserToken(r))
 	if err != nil {
 		return nil, -1, err
 	}
@@ -474,15 +480,25 @@ func GetUserBookmarks(r *http.Request, id, mode string, page int) ([]ArtworkBrie
 	for index, value := range body.Artworks {
 		var artwork ArtworkBrief
 
+		// read separately, so that bookmarks of deleted works can still be managed
+		var bookmark struct {
+			Data struct {
+				ID string `json:"id"`
+			} `json:"bookmarkData"`
+		}
+		_ = json.Unmarshal([]byte(value), &bookmark)
+
 		err = json.Unmarshal([]byte(value), &artwork)
 		if err != nil {
 			artworks[index] = ArtworkBrief{
-				ID:        "#",
-				Title:     "Deleted or Private",
-				Thumbnail: "https://s.pximg.net/common/images/limit_unknown_360.png",
+				ID:         "#",
+				Title:      "Deleted or Private",
+				Thumbnail:  "https://s.pximg.net/common/images/limit_unknown_360.png",
+				BookmarkID: bookmark.Data.ID,
 			}
 			continue
 		}
+		artwork.BookmarkID = bookmark.Data.ID
 		artworks[index] = artwork
 	}
 
@@ -24,6 +24,7 @@ require (
 	github.com/yargevad/filepathx v1.0.0
 	github.com/zeebo/xxh3 v1.0.2
 	go.uber.org/zap v1.27.0
+	golang.org/x/image v0.18.0
 	golang.org/x/net v0.30.0
 )
 
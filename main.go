@@ -16,6 +16,7 @@ import (
 	"codeberg.org/vnpower/pixivfe/v2/config"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/audit"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
 	"codeberg.org/vnpower/pixivfe/v2/server/middleware"
 	"codeberg.org/vnpower/pixivfe/v2/server/proxy_checker"
 	"codeberg.org/vnpower/pixivfe/v2/server/template"
@@ -32,6 +33,9 @@ func main() {
 	log.Println("Audit logger initialized")
 	i18n.Init()
 	template.Init(config.GlobalConfig.InDevelopment, "assets/views")
+	if err := image_proxy.Init(); err != nil {
+		log.Fatalf("Failed to initialize image proxy: %v", err)
+	}
 
 	// Conditionally initialize and start the proxy checker
 	if config.GlobalConfig.ProxyCheckEnabled {
@@ -73,6 +77,7 @@ func main() {
 	router.Use(middleware.SetPrivacyHeaders)   // all pages need this
 	router.Use(middleware.HandleError)         // if the inner handler fails, this shows the error page instead
 	router.Use(middleware.InitializeRateLimiter())
+	router.Use(middleware.ChallengeScrapers) // after the rate limiter, so that challenge pages are rate limited too
 
 	// watch and compile sass when in development mode
 	if config.GlobalConfig.InDevelopment {
@@ -0,0 +1,352 @@
+// Package epub builds EPUB 3 books out of Pixiv novels.
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	stdimage "image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// FetchFunc fetches the image at url. The caller closes the returned body.
+type FetchFunc func(url string) (io.ReadCloser, error)
+
+// NavPoint is an entry of the table of contents.
+type NavPoint struct {
+	Title    string
+	Href     string // relative to the text directory, e.g. "n0_p1.xhtml#c2"
+	Children []NavPoint
+}
+
+// Book is an EPUB 3 book. Fill in the metadata, add content with AddNovel, then call Write.
+type Book struct {
+	Identifier  string // a unique URN, e.g. urn:pixiv:novel:123
+	Title       string
+	Author      string
+	Language    string // BCP 47, e.g. "ja"
+	Description string // plain text
+	Tags        []string
+	Date        time.Time
+	Modified    time.Time
+	Vertical    bool   // vertical writing, read right to left
+	CoverURL    string // optional
+
+	pages  []page
+	nav    []NavPoint
+	images []image
+	// imagePaths maps image URLs to their paths in the book, so that every image is only included once
+	imagePaths map[string]string
+}
+
+type page struct {
+	name  string // file name in the text directory
+	title string
+	body  string // XHTML
+}
+
+type image struct {
+	url       string
+	path      string // relative to the package directory
+	mediaType string
+}
+
+const (
+	packageDir = "OEBPS"
+	textDir    = "text"
+	imageDir   = "images"
+)
+
+var imageMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// addImage registers an image and returns its path relative to the text directory.
+func (b *Book) addImage(url string) string {
+	if b.imagePaths == nil {
+		b.imagePaths = make(map[string]string)
+	}
+	if p, ok := b.imagePaths[url]; ok {
+		return "../" + p
+	}
+
+	ext := strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	mediaType, ok := imageMediaTypes[ext]
+	if !ok {
+		ext, mediaType = ".jpg", "image/jpeg"
+	}
+
+	p := fmt.Sprintf("%s/%d%s", imageDir, len(b.images), ext)
+	b.images = append(b.images, image{url: url, path: p, mediaType: mediaType})
+	b.imagePaths[url] = p
+	return "../" + p
+}
+
+func (b *Book) language() string {
+	if b.Language == "" {
+		return "ja"
+	}
+	return b.Language
+}
+
+// Write writes the book as an EPUB, fetching the images with fetch as they are needed.
+//
+// The text is written first and the images last, so the book can be streamed to the client while images are still downloading.
+func (b *Book) Write(w io.Writer, fetch FetchFunc) error {
+	coverPath := ""
+	if b.CoverURL != "" {
+		coverPath = strings.TrimPrefix(b.addImage(b.CoverURL), "../")
+	}
+
+	out := zip.NewWriter(w)
+
+	// the mimetype must come first, uncompressed
+	if err := writeFile(out, "mimetype", zip.Store, "application/epub+zip"); err != nil {
+		return err
+	}
+	if err := writeFile(out, "META-INF/container.xml", zip.Deflate, containerXML); err != nil {
+		return err
+	}
+	if err := writeFile(out, packageDir+"/content.opf", zip.Deflate, b.packageDocument(coverPath)); err != nil {
+		return err
+	}
+	if err := writeFile(out, packageDir+"/nav.xhtml", zip.Deflate, b.navDocument()); err != nil {
+		return err
+	}
+	if err := writeFile(out, packageDir+"/style.css", zip.Deflate, b.stylesheet()); err != nil {
+		return err
+	}
+	if coverPath != "" {
+		body := fmt.Sprintf(`<div class="cover"><img src="../%s" alt="%s"/></div>`, coverPath, escape(b.Title))
+		if err := writeFile(out, packageDir+"/"+textDir+"/cover.xhtml", zip.Deflate, b.xhtml(b.Title, body, "../")); err != nil {
+			return err
+		}
+	}
+	for _, page := range b.pages {
+		if err := writeFile(out, packageDir+"/"+textDir+"/"+page.name, zip.Deflate, b.xhtml(page.title, page.body, "../")); err != nil {
+			return err
+		}
+	}
+
+	for _, image := range b.images {
+		if err := writeImage(out, packageDir+"/"+image.path, image, fetch); err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
+
+func writeFile(out *zip.Writer, name string, method uint16, content string) error {
+	file, err := out.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, content)
+	return err
+}
+
+// writeImage fetches an image into the book. Images that can't be fetched are replaced by a placeholder,
+// since the pages referring to them have already been written.
+func writeImage(out *zip.Writer, name string, image image, fetch FetchFunc) error {
+	body, err := fetch(image.url)
+	if err != nil {
+		log.Printf("Failed to fetch image %s for EPUB, using a placeholder: %v", image.url, err)
+		body = io.NopCloser(bytes.NewReader(placeholderImage(image.mediaType)))
+	}
+	defer body.Close()
+
+	file, err := out.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	return err
+}
+
+// placeholderWebP is a transparent 1×1 WebP, since WebP can't be encoded with the standard library
+var placeholderWebP = []byte{
+	0x52, 0x49, 0x46, 0x46, 0x1a, 0x00, 0x00, 0x00, 0x57, 0x45, 0x42, 0x50, 0x56, 0x50, 0x38, 0x4c,
+	0x0d, 0x00, 0x00, 0x00, 0x2f, 0x00, 0x00, 0x00, 0x10, 0x07, 0x10, 0x11, 0x11, 0x88, 0x88, 0xfe, 0x07, 0x00,
+}
+
+// placeholderImage returns a plain gray image of mediaType, to stand in for an image that couldn't be fetched.
+func placeholderImage(mediaType string) []byte {
+	if mediaType == "image/webp" {
+		return placeholderWebP
+	}
+
+	img := stdimage.NewGray(stdimage.Rect(0, 0, 320, 240))
+	for i := range img.Pix {
+		img.Pix[i] = 0xdd
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/gif":
+		err = gif.Encode(&buf, img, &gif.Options{NumColors: 2})
+	default:
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		log.Printf("Failed to encode EPUB placeholder image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="` + packageDir + `/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func (b *Book) packageDocument(coverPath string) string {
+	var s strings.Builder
+	modified := b.Modified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&s, `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="%s">`+"\n", escape(b.language()))
+	s.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	fmt.Fprintf(&s, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", escape(b.Identifier))
+	fmt.Fprintf(&s, "    <dc:title>%s</dc:title>\n", escape(b.Title))
+	fmt.Fprintf(&s, "    <dc:language>%s</dc:language>\n", escape(b.language()))
+	if b.Author != "" {
+		fmt.Fprintf(&s, "    <dc:creator id=\"author\">%s</dc:creator>\n", escape(b.Author))
+		s.WriteString("    <meta refines=\"#author\" property=\"role\" scheme=\"marc:relators\">aut</meta>\n")
+	}
+	if b.Description != "" {
+		fmt.Fprintf(&s, "    <dc:description>%s</dc:description>\n", escape(b.Description))
+	}
+	for _, tag := range b.Tags {
+		fmt.Fprintf(&s, "    <dc:subject>%s</dc:subject>\n", escape(tag))
+	}
+	if !b.Date.IsZero() {
+		fmt.Fprintf(&s, "    <dc:date>%s</dc:date>\n", b.Date.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&s, "    <meta property=\"dcterms:modified\">%s</meta>\n", modified.UTC().Format("2006-01-02T15:04:05Z"))
+	if coverPath != "" {
+		s.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+	}
+	s.WriteString("  </metadata>\n")
+
+	s.WriteString("  <manifest>\n")
+	s.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	s.WriteString("    <item id=\"style\" href=\"style.css\" media-type=\"text/css\"/>\n")
+	if coverPath != "" {
+		fmt.Fprintf(&s, "    <item id=\"cover\" href=\"%s/cover.xhtml\" media-type=\"application/xhtml+xml\"/>\n", textDir)
+	}
+	for i, page := range b.pages {
+		fmt.Fprintf(&s, "    <item id=\"page-%d\" href=\"%s/%s\" media-type=\"application/xhtml+xml\"/>\n", i, textDir, page.name)
+	}
+	for i, image := range b.images {
+		id := fmt.Sprintf("image-%d", i)
+		properties := ""
+		if image.path == coverPath {
+			id = "cover-image"
+			properties = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&s, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"%s/>\n", id, image.path, image.mediaType, properties)
+	}
+	s.WriteString("  </manifest>\n")
+
+	direction := "ltr"
+	if b.Vertical {
+		direction = "rtl"
+	}
+	fmt.Fprintf(&s, "  <spine page-progression-direction=\"%s\">\n", direction)
+	if coverPath != "" {
+		s.WriteString("    <itemref idref=\"cover\"/>\n")
+	}
+	for i := range b.pages {
+		fmt.Fprintf(&s, "    <itemref idref=\"page-%d\"/>\n", i)
+	}
+	s.WriteString("  </spine>\n")
+	s.WriteString("</package>\n")
+	return s.String()
+}
+
+func (b *Book) navDocument() string {
+	nav := b.nav
+	// a single novel doesn't need a level for itself
+	if len(nav) == 1 && len(nav[0].Children) > 0 {
+		nav = nav[0].Children
+	}
+
+	var s strings.Builder
+	s.WriteString(`<nav epub:type="toc" id="toc">` + "\n")
+	fmt.Fprintf(&s, "<h1>%s</h1>\n", escape(b.Title))
+	writeNavList(&s, nav)
+	s.WriteString("</nav>")
+
+	return b.xhtml(b.Title, s.String(), "")
+}
+
+func writeNavList(s *strings.Builder, points []NavPoint) {
+	s.WriteString("<ol>\n")
+	for _, point := range points {
+		fmt.Fprintf(s, `<li><a href="%s/%s">%s</a>`, textDir, escape(point.Href), escape(point.Title))
+		if len(point.Children) > 0 {
+			s.WriteString("\n")
+			writeNavList(s, point.Children)
+		}
+		s.WriteString("</li>\n")
+	}
+	s.WriteString("</ol>\n")
+}
+
+func (b *Book) stylesheet() string {
+	css := `body { line-height: 1.8; }
+p { margin: 0; }
+h1, h2 { margin: 1em 0; }
+img { max-width: 100%; max-height: 100%; }
+.cover { text-align: center; }
+rt { font-size: 0.5em; }
+.missing-image { color: gray; }
+`
+	if b.Vertical {
+		css += `html { writing-mode: vertical-rl; -epub-writing-mode: vertical-rl; -webkit-writing-mode: vertical-rl; }
+`
+	}
+	return css
+}
+
+// xhtml wraps body into an XHTML content document. root is the path from the document to the package directory.
+func (b *Book) xhtml(title, body, root string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%[1]s" lang="%[1]s">
+<head>
+<meta charset="UTF-8"/>
+<title>%[2]s</title>
+<link rel="stylesheet" type="text/css" href="%[3]s"/>
+</head>
+<body>
+%[4]s
+</body>
+</html>
+`, escape(b.language()), escape(title), root+"style.css", body)
+}
+
+func escape(s string) string {
+	return html.EscapeString(s)
+}
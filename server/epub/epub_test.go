@@ -0,0 +1,197 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	stdimage "image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+)
+
+func testNovel() core.Novel {
+	content := "[chapter:First]\n[[rb:漢字 > かんじ]] & <b>text</b>\n\n[pixivimage:100-2]\n" +
+		"[newpage]\n[chapter:Second]\n[jump:1] [[jumpuri:site > https://example.com/?a=1&b=2]] [[jumpuri:bad > javascript:alert(1)]]\n[uploadedimage:5]"
+	novel := core.Novel{ID: "1", Title: "novel", Content: content, Document: core.ParseNovelContent(content)}
+
+	// what GetNovelByID does
+	images := map[string]string{
+		"100-2": "/proxy/i.pximg.net/img-original/100_p1.png",
+		"5":     "/proxy/i.pximg.net/novel-cover-original/5.jpg",
+	}
+	for _, page := range novel.Document.Pages {
+		for _, block := range page.Blocks {
+			for i, node := range block.Nodes {
+				if node.Kind == core.NovelNodeImage {
+					block.Nodes[i].URL = images[node.ImageID]
+				}
+			}
+		}
+	}
+	return novel
+}
+
+func writeBook(t *testing.T, book *Book) map[string]string {
+	var buf bytes.Buffer
+	fetched := 0
+	err := book.Write(&buf, func(url string) (io.ReadCloser, error) {
+		fetched++
+		return io.NopCloser(strings.NewReader("image:" + url)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.File[0].Name != "mimetype" || archive.File[0].Method != zip.Store {
+		t.Errorf("mimetype must be the first file, uncompressed")
+	}
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(data)
+
+		if strings.HasSuffix(file.Name, ".xhtml") || strings.HasSuffix(file.Name, ".opf") || strings.HasSuffix(file.Name, ".xml") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := decoder.Token(); err != nil {
+					if !errors.Is(err, io.EOF) {
+						t.Errorf("%s is not well-formed: %v", file.Name, err)
+					}
+					break
+				}
+			}
+		}
+	}
+	if fetched != len(book.images) {
+		t.Errorf("fetched %d images, want %d", fetched, len(book.images))
+	}
+	return files
+}
+
+func TestNovelBook(t *testing.T) {
+	book := Book{Identifier: "urn:pixiv:novel:1", Title: "novel", Author: "author", Language: "ja", Tags: []string{"tag"}}
+	book.AddNovel(testNovel(), "novel")
+	files := writeBook(t, &book)
+
+	first := files["OEBPS/text/n0_p0.xhtml"]
+	for _, want := range []string{
+		`<h2 id="c1">First</h2>`,
+		`<ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby> &amp; &lt;b&gt;text&lt;/b&gt;`,
+		`<p><br/></p>`,
+		`<img src="../images/0.png" alt="[pixivimage:100-2]"/>`,
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("page 1 is missing %q:\n%s", want, first)
+		}
+	}
+
+	second := files["OEBPS/text/n0_p1.xhtml"]
+	for _, want := range []string{
+		`<h2 id="c2">Second</h2>`,
+		`<a href="n0_p0.xhtml">To page 1</a>`,
+		`<a href="https://example.com/?a=1&amp;b=2">site</a>`,
+		` bad</p>`,
+		`<img src="../images/1.jpg" alt="[uploadedimage:5]"/>`,
+	} {
+		if !strings.Contains(second, want) {
+			t.Errorf("page 2 is missing %q:\n%s", want, second)
+		}
+	}
+
+	nav := files["OEBPS/nav.xhtml"]
+	if !strings.Contains(nav, `<a href="text/n0_p0.xhtml#c1">First</a>`) || !strings.Contains(nav, `<a href="text/n0_p1.xhtml#c2">Second</a>`) {
+		t.Errorf("unexpected navigation document:\n%s", nav)
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{"<dc:creator", "author", "<dc:language>ja</dc:language>", "<dc:subject>tag</dc:subject>", `page-progression-direction="ltr"`} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("package document is missing %q", want)
+		}
+	}
+	if files["OEBPS/images/0.png"] != "image:/proxy/i.pximg.net/img-original/100_p1.png" {
+		t.Errorf("unexpected image content: %q", files["OEBPS/images/0.png"])
+	}
+}
+
+func TestVerticalBookWithCover(t *testing.T) {
+	book := Book{Identifier: "urn:pixiv:novel-series:1", Title: "series", Vertical: true, CoverURL: "/proxy/i.pximg.net/cover.jpg"}
+	book.AddNovel(testNovel(), "#1 novel")
+	book.AddNovel(testNovel(), "#2 novel")
+	files := writeBook(t, &book)
+
+	if !strings.Contains(files["OEBPS/style.css"], "writing-mode: vertical-rl") {
+		t.Error("vertical books need vertical writing mode")
+	}
+	opf := files["OEBPS/content.opf"]
+	if !strings.Contains(opf, `page-progression-direction="rtl"`) || !strings.Contains(opf, `properties="cover-image"`) {
+		t.Errorf("unexpected package document:\n%s", opf)
+	}
+	if _, ok := files["OEBPS/text/cover.xhtml"]; !ok {
+		t.Error("missing cover page")
+	}
+
+	// both novels share the same images
+	if len(book.images) != 3 {
+		t.Errorf("got %d images, want 3", len(book.images))
+	}
+	nav := files["OEBPS/nav.xhtml"]
+	if !strings.Contains(nav, `<a href="text/n1_p0.xhtml">#2 novel</a>`) || !strings.Contains(nav, `<a href="text/n1_p1.xhtml#c2">Second</a>`) {
+		t.Errorf("unexpected navigation document:\n%s", nav)
+	}
+}
+
+func TestMissingImage(t *testing.T) {
+	book := Book{Identifier: "urn:pixiv:novel:1", Title: "novel", Author: "author", Language: "ja", CoverURL: "https://i.pximg.net/c/cover.png"}
+	book.AddNovel(testNovel(), "novel")
+
+	var buf bytes.Buffer
+	err := book.Write(&buf, func(url string) (io.ReadCloser, error) {
+		return nil, errors.New("upstream responded with 404 Not Found")
+	})
+	if err != nil {
+		t.Fatalf("Expected failed images to be replaced, got %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := 0
+	for _, file := range archive.File {
+		if !strings.HasPrefix(file.Name, packageDir+"/"+imageDir+"/") {
+			continue
+		}
+		images++
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, format, err := stdimage.DecodeConfig(reader)
+		reader.Close()
+		if err != nil || "image/"+format != imageMediaTypes[path.Ext(file.Name)] {
+			t.Errorf("Expected a placeholder matching %s, got %q, %v", file.Name, format, err)
+		}
+	}
+	if images != len(book.images) {
+		t.Errorf("Expected %d images, got %d", len(book.images), images)
+	}
+}
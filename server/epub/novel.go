@@ -0,0 +1,81 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+)
+
+// AddNovel appends a novel to the book, one content document per page ([newpage]).
+// title is the novel's entry in the table of contents; its chapters ([chapter:]) are nested below it.
+func (b *Book) AddNovel(novel core.Novel, title string) {
+	index := len(b.nav)
+	point := NavPoint{Title: title, Href: pageName(index, 0)}
+
+	for _, chapter := range novel.Document.Chapters {
+		point.Children = append(point.Children, NavPoint{
+			Title: chapter.Title,
+			Href:  fmt.Sprintf("%s#c%d", pageName(index, chapter.Page-1), chapter.Number),
+		})
+	}
+
+	for i, novelPage := range novel.Document.Pages {
+		var body strings.Builder
+		if i == 0 {
+			fmt.Fprintf(&body, "<h1>%s</h1>\n", escape(title))
+		}
+		for _, block := range novelPage.Blocks {
+			b.writeBlock(&body, index, block)
+		}
+		b.pages = append(b.pages, page{name: pageName(index, i), title: title, body: body.String()})
+	}
+
+	b.nav = append(b.nav, point)
+}
+
+func pageName(novel, page int) string {
+	return fmt.Sprintf("n%d_p%d.xhtml", novel, page)
+}
+
+func (b *Book) writeBlock(body *strings.Builder, index int, block core.NovelBlock) {
+	if block.Kind == core.NovelBlockChapter {
+		fmt.Fprintf(body, "<h2 id=\"c%d\">", block.Chapter)
+		b.writeNodes(body, index, block.Nodes)
+		body.WriteString("</h2>\n")
+		return
+	}
+
+	body.WriteString("<p>")
+	if len(block.Nodes) == 0 {
+		// keep the blank lines authors use to separate scenes
+		body.WriteString("<br/>")
+	}
+	b.writeNodes(body, index, block.Nodes)
+	body.WriteString("</p>\n")
+}
+
+func (b *Book) writeNodes(body *strings.Builder, index int, nodes []core.NovelNode) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case core.NovelNodeRuby:
+			fmt.Fprintf(body, "<ruby>%s<rp>(</rp><rt>%s</rt><rp>)</rp></ruby>", escape(node.Text), escape(node.Ruby))
+		case core.NovelNodeLink:
+			if node.URL == "" {
+				body.WriteString(escape(node.Text))
+			} else {
+				fmt.Fprintf(body, `<a href="%s">%s</a>`, escape(node.URL), escape(node.Text))
+			}
+		case core.NovelNodeJump:
+			fmt.Fprintf(body, `<a href="%s">%s</a>`, pageName(index, node.Page-1), escape(fmt.Sprintf("To page %d", node.Page)))
+		case core.NovelNodeImage:
+			if node.URL == "" {
+				body.WriteString(`<span class="missing-image">[Image unavailable]</span>`)
+			} else {
+				fmt.Fprintf(body, `<img src="%s" alt="%s"/>`, escape(b.addImage(node.URL)), escape(node.Markup))
+			}
+		default:
+			body.WriteString(escape(node.Text))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package image_proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// encodeAPNG encodes frames as an animated PNG that loops forever.
+//
+// The standard library can only write still PNGs, so every frame is encoded on its own,
+// and the IDAT chunks are then rearranged into an APNG (https://wiki.mozilla.org/APNG_Specification).
+// All frames must have the same size, and must encode to the same color type.
+func encodeAPNG(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	if len(frames) == 0 || len(frames) != len(delays) {
+		return errors.New("apng: frame count mismatch")
+	}
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	var header []byte
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	sequence := uint32(0)
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, frame); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			header = chunks[0].data
+			writePNGChunk(&out, "IHDR", header)
+
+			acTL := make([]byte, 8)
+			binary.BigEndian.PutUint32(acTL[0:], uint32(len(frames)))
+			binary.BigEndian.PutUint32(acTL[4:], 0) // loop forever
+			writePNGChunk(&out, "acTL", acTL)
+		} else if !bytes.Equal(chunks[0].data, header) {
+			return errors.New("apng: frames have different sizes or color types")
+		}
+
+		bounds := frame.Bounds()
+		fcTL := make([]byte, 26)
+		binary.BigEndian.PutUint32(fcTL[0:], sequence)
+		binary.BigEndian.PutUint32(fcTL[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fcTL[8:], uint32(bounds.Dy()))
+		// x and y offsets are 0
+		binary.BigEndian.PutUint16(fcTL[20:], uint16(min(delays[i].Milliseconds(), 65535)))
+		binary.BigEndian.PutUint16(fcTL[22:], 1000)
+		// dispose_op and blend_op are 0 (none, source)
+		writePNGChunk(&out, "fcTL", fcTL)
+		sequence++
+
+		for _, chunk := range chunks {
+			switch {
+			case chunk.kind == "PLTE" && i == 0:
+				writePNGChunk(&out, "PLTE", chunk.data)
+			case chunk.kind == "IDAT" && i == 0:
+				writePNGChunk(&out, "IDAT", chunk.data)
+			case chunk.kind == "IDAT":
+				fdAT := make([]byte, 4, 4+len(chunk.data))
+				binary.BigEndian.PutUint32(fdAT, sequence)
+				writePNGChunk(&out, "fdAT", append(fdAT, chunk.data...))
+				sequence++
+			}
+		}
+	}
+
+	writePNGChunk(&out, "IEND", nil)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("apng: not a PNG")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data[0:4])
+		if uint64(len(data)) < 12+uint64(length) {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		chunks = append(chunks, pngChunk{kind: string(data[4:8]), data: data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" {
+		return nil, errors.New("apng: missing IHDR")
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	w.Write(length[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
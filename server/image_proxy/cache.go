@@ -0,0 +1,309 @@
+package image_proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Metadata is stored alongside every cached file.
+type Metadata struct {
+	ContentType string
+	ModTime     time.Time // served as Last-Modified
+	ETag        string    // upstream ETag, used to revalidate expired entries
+	Expires     time.Time // zero means the entry never expires
+}
+
+// Fresh reports whether the entry can be served without revalidating it upstream.
+func (meta Metadata) Fresh(now time.Time) bool {
+	return meta.Expires.IsZero() || now.Before(meta.Expires)
+}
+
+// CacheStats is a snapshot of the cache counters, shown on /diagnostics.
+type CacheStats struct {
+	Entries   int
+	Size      int64 // in bytes
+	MaxSize   int64 // in bytes, 0 means unlimited
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// HitRatio returns the percentage of lookups that were served from the cache, formatted for display.
+func (s CacheStats) HitRatio() string {
+	if s.Hits+s.Misses == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(s.Hits)*100/float64(s.Hits+s.Misses))
+}
+
+// SizeMiB returns Size in mebibytes, formatted for display.
+func (s CacheStats) SizeMiB() string { return fmt.Sprintf("%.1f MiB", float64(s.Size)/(1<<20)) }
+
+// MaxSizeMiB returns MaxSize in mebibytes, formatted for display.
+func (s CacheStats) MaxSizeMiB() string { return fmt.Sprintf("%.1f MiB", float64(s.MaxSize)/(1<<20)) }
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// DiskCache stores files on disk, keyed by an arbitrary string (usually the upstream URL).
+//
+// Every entry consists of two files named after the SHA-256 of the key:
+// the content itself, and a .json file containing its Metadata.
+//
+// The total size of the cache is capped; when it is exceeded, the least recently used entries are evicted.
+// The LRU order is kept in memory and rebuilt from file modification times on startup.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+// NewDiskCache creates a cache that stores up to maxSize bytes in dir, creating dir if needed.
+// Entries already present in dir are kept. If maxSize is 0, the cache grows without limit.
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the entries already on disk, oldest first, and removes leftovers from interrupted writes.
+func (c *DiskCache) load() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type existing struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var found []existing
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		if dirEntry.IsDir() || strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		metaInfo, err := os.Stat(filepath.Join(c.dir, name+".json"))
+		if err != nil {
+			// content without metadata is useless
+			os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		found = append(found, existing{name, info.Size() + metaInfo.Size(), info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime.Before(found[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range found {
+		c.entries[e.name] = c.lru.PushFront(&cacheEntry{name: e.name, size: e.size})
+		c.stats.Size += e.size
+	}
+	c.evictLocked()
+	return nil
+}
+
+func (c *DiskCache) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get opens the cached file for key. The caller must close the returned file.
+//
+// The entry is returned even if it has expired; use Metadata.Fresh to decide whether to revalidate it.
+func (c *DiskCache) Get(key string) (*os.File, Metadata, bool) {
+	var meta Metadata
+	name := c.name(key)
+	p := filepath.Join(c.dir, name)
+
+	c.mu.Lock()
+	element, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(element)
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, meta, false
+	}
+
+	rawMeta, err := os.ReadFile(p + ".json")
+	if err != nil {
+		c.Remove(key)
+		return nil, meta, false
+	}
+	if err := json.Unmarshal(rawMeta, &meta); err != nil {
+		c.Remove(key)
+		return nil, meta, false
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		c.Remove(key)
+		return nil, meta, false
+	}
+
+	// persist the LRU order across restarts
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+
+	return file, meta, true
+}
+
+// Put stores data under key, evicting the least recently used entries if the cache becomes too large.
+// Files are written to a temporary name first, so that readers never see a partially written entry.
+func (c *DiskCache) Put(key string, meta Metadata, data []byte) error {
+	name := c.name(key)
+	p := filepath.Join(c.dir, name)
+
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data) + len(rawMeta))
+	if c.maxSize > 0 && size > c.maxSize {
+		return nil
+	}
+
+	if err := writeFileAtomic(p, data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p+".json", rawMeta); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setSizeLocked(name, size)
+	c.evictLocked()
+	return nil
+}
+
+// PutMetadata replaces the metadata of an existing entry, e.g. after it has been revalidated.
+func (c *DiskCache) PutMetadata(key string, meta Metadata) error {
+	name := c.name(key)
+	p := filepath.Join(c.dir, name)
+
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p+".json", rawMeta); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setSizeLocked(name, info.Size()+int64(len(rawMeta)))
+	return nil
+}
+
+// Remove deletes the entry for key, if any.
+func (c *DiskCache) Remove(key string) {
+	name := c.name(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.removeLocked(element)
+	}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.MaxSize = c.maxSize
+	return stats
+}
+
+func (c *DiskCache) setSizeLocked(name string, size int64) {
+	if element, ok := c.entries[name]; ok {
+		entry := element.Value.(*cacheEntry)
+		c.stats.Size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, size: size})
+	c.stats.Size += size
+}
+
+func (c *DiskCache) evictLocked() {
+	for c.maxSize > 0 && c.stats.Size > c.maxSize && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removeLocked deletes an entry from disk and from the index.
+// Readers that already opened the file can keep reading it.
+func (c *DiskCache) removeLocked(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	p := filepath.Join(c.dir, entry.name)
+	os.Remove(p)
+	os.Remove(p + ".json")
+	c.lru.Remove(element)
+	delete(c.entries, entry.name)
+	c.stats.Size -= entry.size
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,94 @@
+package image_proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte{'x'}, 900)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Put(key, Metadata{ContentType: "image/png"}, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "a" is now the most recently used entry, so "b" should be evicted next
+	file, meta, ok := cache.Get("a")
+	if !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(content, data) || meta.ContentType != "image/png" {
+		t.Errorf("Unexpected cached content or metadata: %d bytes, %+v", len(content), meta)
+	}
+
+	if err := cache.Put("d", Metadata{}, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		file, _, ok := cache.Get(key)
+		if !ok {
+			t.Errorf("Expected %s to be cached", key)
+			continue
+		}
+		file.Close()
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 3 || stats.Evictions != 1 || stats.Size > stats.MaxSize {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// entries survive a restart
+	reloaded, err := NewDiskCache(dir, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Stats().Entries != 3 || reloaded.Stats().Size != stats.Size {
+		t.Errorf("Expected reloaded cache to match, got %+v", reloaded.Stats())
+	}
+}
+
+func TestFreshnessExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastModified := now.Add(-100 * time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Time
+		storable bool
+	}{
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, now.Add(time.Minute), true},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, now.Add(2 * time.Minute), true},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, time.Time{}, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, time.Time{}, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, now, true},
+		{"Expires", http.Header{"Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, now.Add(time.Hour), true},
+		{"Last-Modified heuristic", http.Header{"Last-Modified": {lastModified}}, now.Add(10 * time.Hour), true},
+		{"No headers", http.Header{}, now.Add(defaultFreshness), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires, storable := freshnessExpiry(tt.header, now)
+			if !expires.Equal(tt.expected) || storable != tt.storable {
+				t.Errorf("freshnessExpiry() = %v, %v, want %v, %v", expires, storable, tt.expected, tt.storable)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+// ComicChapter is a work of a manga series, to be written to a CBZ.
+type ComicChapter struct {
+	Illust    *core.Illust
+	Order     int      // the position in the series, starting at 1
+	Originals []string // the i.pximg.net URLs of the pages
+}
+
+// ComicInfo is the ComicInfo.xml metadata read by comic servers like Komga and Kavita.
+// See https://anansi-project.github.io/docs/comicinfo/documentation
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	Title       string   `xml:"Title,omitempty"`
+	Series      string   `xml:"Series,omitempty"`
+	Number      string   `xml:"Number,omitempty"`
+	Count       int      `xml:"Count,omitempty"`
+	Summary     string   `xml:"Summary,omitempty"`
+	Year        int      `xml:"Year,omitempty"`
+	Month       int      `xml:"Month,omitempty"`
+	Day         int      `xml:"Day,omitempty"`
+	Writer      string   `xml:"Writer,omitempty"`
+	Penciller   string   `xml:"Penciller,omitempty"`
+	Tags        string   `xml:"Tags,omitempty"`
+	Web         string   `xml:"Web,omitempty"`
+	PageCount   int      `xml:"PageCount,omitempty"`
+	LanguageISO string   `xml:"LanguageISO,omitempty"`
+	Manga       string   `xml:"Manga,omitempty"`
+	AgeRating   string   `xml:"AgeRating,omitempty"`
+}
+
+// NewComicInfo builds the ComicInfo.xml for a CBZ of chapters of series.
+// With a single chapter, the archive describes that chapter; otherwise it describes the series as a whole.
+func NewComicInfo(series core.MangaSeries, chapters []ComicChapter) ComicInfo {
+	info := ComicInfo{
+		Series:    series.Title,
+		Count:     series.Total,
+		Summary:   series.Caption,
+		Web:       fmt.Sprintf("https://www.pixiv.net/user/%s/series/%s", series.UserID, series.ID),
+		Manga:     "Yes",
+		AgeRating: "Everyone",
+	}
+	if info.Summary == "" {
+		info.Summary = series.Description
+	}
+
+	date := series.CreateDate
+	var tags []string
+	seen := make(map[string]bool)
+	for _, chapter := range chapters {
+		illust := chapter.Illust
+		info.PageCount += len(chapter.Originals)
+		if info.Writer == "" {
+			info.Writer = illust.UserName
+			info.Penciller = illust.UserName
+		}
+		if illust.XRestrict != core.Safe {
+			info.AgeRating = "Adults Only 18+"
+		}
+		for _, tag := range illust.Tags {
+			if !seen[tag.Name] {
+				seen[tag.Name] = true
+				tags = append(tags, tag.Name)
+			}
+		}
+	}
+	info.Tags = strings.Join(tags, ",")
+
+	if len(chapters) == 1 {
+		chapter := chapters[0]
+		info.Title = chapter.Illust.Title
+		info.Number = strconv.Itoa(chapter.Order)
+		info.Web = "https://www.pixiv.net/artworks/" + chapter.Illust.ID
+		if description := utils.HTMLToText(string(chapter.Illust.Description)); description != "" {
+			info.Summary = description
+		}
+		date = chapter.Illust.Date
+	} else {
+		info.Title = series.Title
+		if len(chapters) > 0 {
+			date = chapters[0].Illust.Date
+		}
+	}
+
+	if !date.IsZero() {
+		info.Year, info.Month, info.Day = date.Year(), int(date.Month()), date.Day()
+	}
+	return info
+}
+
+// ServeComicArchive streams a CBZ of chapters, with a ComicInfo.xml built from info.
+//
+// Pages are named after their chapter and page number, so that readers sort them in series order.
+func ServeComicArchive(w http.ResponseWriter, r *http.Request, filename string, info ComicInfo, chapters []ComicChapter) error {
+	comicInfo, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.comicbook+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cbz"`, filename))
+
+	out := zip.NewWriter(w)
+	file, err := out.CreateHeader(&zip.FileHeader{Name: "ComicInfo.xml", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append([]byte(xml.Header), comicInfo...)); err != nil {
+		return err
+	}
+
+	for _, chapter := range chapters {
+		for page, original := range chapter.Originals {
+			name := comicPageName(chapter, page, len(chapters) > 1) + path.Ext(original)
+			if err := writeArchiveImage(r, out, name, original, chapter.Illust.Date); err != nil {
+				return i18n.Errorf("Failed to download page %d of artwork %s: %w", page+1, chapter.Illust.ID, err)
+			}
+		}
+	}
+
+	return out.Close()
+}
+
+func comicPageName(chapter ComicChapter, page int, withChapter bool) string {
+	if withChapter {
+		return fmt.Sprintf("c%04d_p%04d", chapter.Order, page+1)
+	}
+	return fmt.Sprintf("p%04d", page+1)
+}
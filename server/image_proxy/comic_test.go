@@ -0,0 +1,109 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+)
+
+func testChapters(upstream string) (core.MangaSeries, []ComicChapter) {
+	series := core.MangaSeries{ID: "7", UserID: "42", Title: "series", Caption: "about", Total: 2}
+	first := &core.Illust{
+		ID:          "100",
+		Title:       "chapter 1",
+		Description: "line 1<br />line &amp; 2",
+		UserName:    "artist",
+		Date:        time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Tags:        []core.Tag{{Name: "a"}, {Name: "b"}},
+	}
+	second := &core.Illust{
+		ID:        "101",
+		Title:     "chapter 2",
+		UserName:  "artist",
+		Date:      time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC),
+		Tags:      []core.Tag{{Name: "b"}, {Name: "c"}},
+		XRestrict: core.R18,
+	}
+	return series, []ComicChapter{
+		{Illust: first, Order: 1, Originals: []string{upstream + "/100_p0.jpg", upstream + "/100_p1.png"}},
+		{Illust: second, Order: 2, Originals: []string{upstream + "/101_p0.jpg"}},
+	}
+}
+
+func TestNewComicInfo(t *testing.T) {
+	series, chapters := testChapters("")
+
+	info := NewComicInfo(series, chapters)
+	if info.Title != "series" || info.Number != "" || info.Count != 2 || info.PageCount != 3 {
+		t.Errorf("unexpected series info: %+v", info)
+	}
+	if info.Tags != "a,b,c" || info.AgeRating != "Adults Only 18+" || info.Summary != "about" {
+		t.Errorf("unexpected series info: %+v", info)
+	}
+	if info.Year != 2024 || info.Month != 1 || info.Day != 2 {
+		t.Errorf("unexpected series date: %d-%d-%d", info.Year, info.Month, info.Day)
+	}
+
+	info = NewComicInfo(series, chapters[:1])
+	if info.Title != "chapter 1" || info.Number != "1" || info.Series != "series" || info.AgeRating != "Everyone" {
+		t.Errorf("unexpected chapter info: %+v", info)
+	}
+	if info.Summary != "line 1\nline & 2" {
+		t.Errorf("unexpected chapter summary: %q", info.Summary)
+	}
+}
+
+func TestServeComicArchive(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	series, chapters := testChapters(upstream.URL)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/user/42/series/7/download.cbz", nil)
+	if err := ServeComicArchive(w, r, "series_7", NewComicInfo(series, chapters), chapters); err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	want := []string{"ComicInfo.xml", "c0001_p0001.jpg", "c0001_p0002.png", "c0002_p0001.jpg"}
+	if len(names) != len(want) {
+		t.Fatalf("got files %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got files %v, want %v", names, want)
+		}
+	}
+
+	reader, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var info ComicInfo
+	if err := xml.NewDecoder(reader).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Series != "series" || info.PageCount != 3 {
+		t.Errorf("unexpected ComicInfo.xml: %+v", info)
+	}
+}
@@ -0,0 +1,215 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+// maxFilenameField limits the length of a single value substituted into a file name, in runes
+const maxFilenameField = 80
+
+// ArtworkMetadata is written to metadata.json in artwork archives.
+type ArtworkMetadata struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	UploadDate  time.Time `json:"uploadDate"`
+	UserID      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	Pages       int       `json:"pageCount"`
+	URL         string    `json:"url"`
+}
+
+// ServeArtworkArchive streams a ZIP of the original images of every page of illust, followed by a metadata.json.
+//
+// originals are the i.pximg.net URLs of the pages. They are fetched one after another and written to the
+// client as they arrive, so the archive is never held in memory.
+func ServeArtworkArchive(w http.ResponseWriter, r *http.Request, illust *core.Illust, originals []string) error {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, illust.ID))
+
+	out := zip.NewWriter(w)
+	for page, original := range originals {
+		name := ArchiveFilename(config.GlobalConfig.DownloadFilenameTemplate, illust, page) + path.Ext(original)
+		if err := writeArchiveImage(r, out, name, original, illust.Date); err != nil {
+			return i18n.Errorf("Failed to download page %d of artwork %s: %w", page+1, illust.ID, err)
+		}
+	}
+
+	metadata, err := json.MarshalIndent(artworkMetadata(illust), "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := out.CreateHeader(&zip.FileHeader{Name: "metadata.json", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(metadata); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// FetchImage requests an image from i.pximg.net for use on the server, e.g. to put it into an archive.
+//
+// The returned body must be closed by the caller. Reading it fails once it turns out to be larger than
+// PIXIVFE_PROXY_MAX_BODY_SIZE, so that a cut off image is never mistaken for a complete one.
+func FetchImage(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Referer", "https://www.pixiv.net/")
+
+	resp, err := utils.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, i18n.Errorf("upstream responded with %s", resp.Status)
+	}
+	if err := core.CheckProxyResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	maxSize := int64(config.GlobalConfig.ProxyMaxBodySize) << 20
+	if maxSize <= 0 {
+		return resp.Body, nil
+	}
+	return &sizeLimitedBody{ReadCloser: resp.Body, remaining: maxSize, maxSize: maxSize}, nil
+}
+
+// sizeLimitedBody reads at most maxSize bytes from a response body, and returns an error if there are more.
+type sizeLimitedBody struct {
+	io.ReadCloser
+	remaining int64
+	maxSize   int64
+}
+
+func (b *sizeLimitedBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		// read one byte past the limit to tell whether the image ends there
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, i18n.Errorf("image is larger than %d bytes", b.maxSize)
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// writeArchiveImage copies an image from i.pximg.net into the archive.
+// Images are stored without compression, since they are already compressed.
+func writeArchiveImage(r *http.Request, out *zip.Writer, name, url string, modified time.Time) error {
+	body, err := FetchImage(r.Context(), url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := out.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	return err
+}
+
+func artworkMetadata(illust *core.Illust) ArtworkMetadata {
+	tags := make([]string, 0, len(illust.Tags))
+	for _, tag := range illust.Tags {
+		tags = append(tags, tag.Name)
+	}
+
+	return ArtworkMetadata{
+		ID:          illust.ID,
+		Title:       illust.Title,
+		Description: string(illust.Description),
+		Tags:        tags,
+		UploadDate:  illust.Date,
+		UserID:      illust.UserID,
+		UserName:    illust.UserName,
+		Pages:       illust.Pages,
+		URL:         "https://www.pixiv.net/artworks/" + illust.ID,
+	}
+}
+
+// ArchiveFilename expands a file name template (PIXIVFE_DOWNLOAD_FILENAME_TEMPLATE) for a page of illust,
+// without the extension. The placeholders are:
+//
+//	{artist}     the artist's name
+//	{artist_id}  the artist's user ID
+//	{id}         the artwork ID
+//	{title}      the artwork title
+//	{page}       the page number, starting at 0 like Pixiv's own file names
+//
+// Substituted values can't contain path separators. If the template has no {page}, the page number is appended,
+// so that every page gets a distinct name.
+func ArchiveFilename(template string, illust *core.Illust, page int) string {
+	if template == "" {
+		template = "{artist}_{id}_p{page}"
+	}
+	if !strings.Contains(template, "{page}") {
+		template += "_p{page}"
+	}
+
+	name := strings.NewReplacer(
+		"{artist}", sanitizeFilename(illust.UserName),
+		"{artist_id}", sanitizeFilename(illust.UserID),
+		"{id}", sanitizeFilename(illust.ID),
+		"{title}", sanitizeFilename(illust.Title),
+		"{page}", strconv.Itoa(page),
+	).Replace(template)
+
+	// the template itself comes from the instance owner, but never let entries escape the archive
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" {
+		return strconv.Itoa(page)
+	}
+	return name
+}
+
+// sanitizeFilename makes s safe to use as (part of) a file name on common file systems.
+func sanitizeFilename(s string) string {
+	var b strings.Builder
+	count := 0
+	for _, c := range s {
+		if count == maxFilenameField {
+			break
+		}
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, c), unicode.IsControl(c):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(c)
+		}
+		count++
+	}
+	return strings.Trim(b.String(), " .")
+}
@@ -0,0 +1,150 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/core"
+)
+
+func testIllust() *core.Illust {
+	return &core.Illust{
+		ID:          "115365120",
+		Title:       "a/b: c?",
+		Description: "<p>hello</p>",
+		UserID:      "42",
+		UserName:    "artist ",
+		Date:        time.Date(2024, 1, 21, 11, 50, 51, 0, time.UTC),
+		Tags:        []core.Tag{{Name: "tag1"}, {Name: "tag2"}},
+		Pages:       2,
+	}
+}
+
+func TestArchiveFilename(t *testing.T) {
+	illust := testIllust()
+	tests := []struct {
+		template string
+		page     int
+		want     string
+	}{
+		{"{artist}_{id}_p{page}", 0, "artist_115365120_p0"},
+		{"{title} ({artist_id})", 1, "a_b_ c_ (42)_p1"},
+		{"", 3, "artist_115365120_p3"},
+		{"../{id}/{page}", 2, "115365120/2"},
+	}
+	for _, test := range tests {
+		if got := ArchiveFilename(test.template, illust, test.page); got != test.want {
+			t.Errorf("ArchiveFilename(%q, %d) = %q, want %q", test.template, test.page, got, test.want)
+		}
+	}
+}
+
+func TestServeArtworkArchive(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, "image"+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	illust := testIllust()
+	originals := []string{upstream.URL + "/115365120_p0.png", upstream.URL + "/115365120_p1.png"}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/artworks/115365120/download.zip", nil)
+	if err := ServeArtworkArchive(w, r, illust, originals); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	body := w.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 3 {
+		t.Fatalf("got %d files, want 3", len(archive.File))
+	}
+
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(data)
+	}
+
+	if got := files["artist_115365120_p1.png"]; got != "image/115365120_p1.png" {
+		t.Errorf("page 1 = %q", got)
+	}
+
+	var metadata ArtworkMetadata
+	if err := json.Unmarshal([]byte(files["metadata.json"]), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Title != illust.Title || len(metadata.Tags) != 2 || !metadata.UploadDate.Equal(illust.Date) || metadata.Description != "<p>hello</p>" {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+}
+
+func TestServeArtworkArchiveUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+	defer upstream.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/artworks/115365120/download.zip", nil)
+	if err := ServeArtworkArchive(w, r, testIllust(), []string{upstream.URL + "/missing.png"}); err == nil {
+		t.Error("expected an error for a missing page")
+	}
+}
+
+func TestFetchImageTooLarge(t *testing.T) {
+	config.GlobalConfig.ProxyMaxBodySize = 1
+	defer func() { config.GlobalConfig.ProxyMaxBodySize = 0 }()
+
+	size := 1 << 20
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Length, so that the size is only known while reading
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(make([]byte, size/2))
+		w.(http.Flusher).Flush()
+		w.Write(make([]byte, size-size/2))
+		if r.URL.Path == "/large.jpg" {
+			w.Write([]byte{0})
+		}
+	}))
+	defer upstream.Close()
+
+	for path, tooLarge := range map[string]bool{"/exact.jpg": false, "/large.jpg": true} {
+		body, err := FetchImage(context.Background(), upstream.URL+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, body)
+		body.Close()
+		if tooLarge && err == nil {
+			t.Errorf("%s: expected an error after %d bytes", path, n)
+		}
+		if !tooLarge && (err != nil || n != int64(size)) {
+			t.Errorf("%s: expected %d bytes, got %d, %v", path, size, n, err)
+		}
+	}
+}
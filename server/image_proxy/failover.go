@@ -0,0 +1,186 @@
+package image_proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/server/proxy_checker"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+const (
+	// failoverHeaderTimeout is how long a proxy has to start responding before the next one is tried
+	failoverHeaderTimeout = 5 * time.Second
+	// maxFailoverProxies is how many working proxies are tried after the preferred one, before giving up and going direct
+	maxFailoverProxies = 2
+)
+
+// pximgImagePattern matches the paths of i.pximg.net images as Pixiv links them, optionally with a thumbnail size.
+// Failures are only reported for such paths, since the client chooses the path.
+var pximgImagePattern = regexp.MustCompile(`^/?(c/[0-9a-z_]+/)?(img-original|img-master|custom-thumb|img-zip-ugoira|user-profile|novel-cover-original|novel-cover-master)/img/\d{4}(/\d{2}){5}/[0-9a-z_]+\.(jpg|jpeg|png|gif|webp|zip)$`)
+
+// ServeFailover serves an i.pximg.net image at path through a chain of image proxies:
+// the user's preferred proxy first, then other proxies known to be working.
+// The built-in proxy, which requests i.pximg.net directly via direct, is tried first if it is the preferred one,
+// and last otherwise.
+//
+// A configured proxy that fails to respond, responds with a server error or responds with something that isn't an image
+// is reported to the proxy checker, which stops offering it until the next check once it failed repeatedly.
+//
+// Proxies entered by users are only contacted on public addresses, see utils.PublicHttpClient.
+func ServeFailover(w http.ResponseWriter, r *http.Request, path string, preferred string, direct func(w http.ResponseWriter) error) error {
+	builtin := !isExternalProxy(preferred)
+
+	var directWriter *failoverWriter
+	var directErr error
+	if builtin {
+		directWriter = &failoverWriter{ResponseWriter: w}
+		directErr = direct(directWriter)
+		if directWriter.committed || (directErr == nil && directWriter.failedStatus == 0) {
+			return directErr
+		}
+		log.Printf("Built-in image proxy failed for %s, failing over", path)
+	}
+
+	for _, proxyURL := range failoverCandidates(preferred) {
+		resp, cancel, ok := tryProxy(r, proxyURL, path)
+		if !ok {
+			continue
+		}
+		err := core.CopyProxyResponse(w, resp, resp.Body)
+		resp.Body.Close()
+		cancel()
+		return err
+	}
+
+	if !builtin {
+		return direct(w)
+	}
+	// every proxy failed as well: report how the built-in one failed
+	if directErr != nil {
+		return directErr
+	}
+	http.Error(w, http.StatusText(directWriter.failedStatus), directWriter.failedStatus)
+	return nil
+}
+
+// failoverWriter holds back a server error written by the built-in proxy, so that other proxies can be tried instead.
+type failoverWriter struct {
+	http.ResponseWriter
+	failedStatus int  // the server error status that was held back
+	committed    bool // whether a response was written to the client
+}
+
+func (w *failoverWriter) WriteHeader(status int) {
+	if status >= 500 {
+		w.failedStatus = status
+		return
+	}
+	w.committed = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *failoverWriter) Write(b []byte) (int, error) {
+	if w.failedStatus != 0 {
+		return len(b), nil
+	}
+	w.committed = true
+	return w.ResponseWriter.Write(b)
+}
+
+// isExternalProxy reports whether proxyURL is an absolute URL, i.e. not the built-in proxy.
+func isExternalProxy(proxyURL string) bool {
+	u, err := url.Parse(proxyURL)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// failoverCandidates returns the external proxies to try, in order:
+// the preferred one if it is external, then up to maxFailoverProxies working ones.
+func failoverCandidates(preferred string) []string {
+	preferred = strings.TrimRight(preferred, "/")
+	var candidates []string
+	if isExternalProxy(preferred) {
+		candidates = append(candidates, preferred)
+	}
+
+	fallbacks := 0
+	for _, proxyURL := range proxy_checker.GetWorkingProxies() {
+		if fallbacks == maxFailoverProxies {
+			break
+		}
+		proxyURL = strings.TrimRight(proxyURL, "/")
+		if proxyURL == preferred {
+			continue
+		}
+		candidates = append(candidates, proxyURL)
+		fallbacks++
+	}
+	return candidates
+}
+
+// proxyClient returns the client to contact an image proxy with.
+func proxyClient(proxyURL string) *http.Client {
+	if proxy_checker.IsConfigured(proxyURL) {
+		return utils.HttpClient
+	}
+	return utils.PublicHttpClient
+}
+
+// tryProxy requests path from proxyURL. If the proxy answered usefully, the response is returned
+// and the caller must close its body and call cancel once done.
+func tryProxy(r *http.Request, proxyURL, path string) (*http.Response, context.CancelFunc, bool) {
+	ctx, cancel := context.WithCancel(r.Context())
+	req, err := http.NewRequestWithContext(ctx, "GET", proxyURL+"/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		cancel()
+		return nil, nil, false
+	}
+	core.ForwardProxyHeaders(r, req)
+
+	// only the time to the response headers is limited, not the transfer of the image itself
+	timer := time.AfterFunc(failoverHeaderTimeout, cancel)
+	resp, err := proxyClient(proxyURL).Do(req)
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if r.Context().Err() == nil {
+			reportFailure(proxyURL, path, err.Error())
+		}
+		return nil, nil, false
+	}
+
+	failed := ""
+	switch {
+	case resp.StatusCode >= 500:
+		failed = resp.Status
+	case resp.StatusCode == http.StatusNotFound:
+		// the image may not exist at all, which isn't the proxy's fault. let the next one (or Pixiv) decide
+	case core.CheckProxyResponse(resp) != nil:
+		failed = "unexpected response: " + resp.Header.Get("Content-Type")
+	default:
+		return resp, cancel, true
+	}
+
+	resp.Body.Close()
+	cancel()
+	if failed != "" {
+		reportFailure(proxyURL, path, failed)
+	}
+	return nil, nil, false
+}
+
+// reportFailure reports a failed request for path to the proxy checker,
+// if the proxy is one of the configured ones and path is a real image path.
+// Proxies entered by users are never offered to others, so there is nothing to report.
+func reportFailure(proxyURL, path, reason string) {
+	log.Printf("Image proxy %s failed: %s", proxyURL, reason)
+	if proxy_checker.IsConfigured(proxyURL) && pximgImagePattern.MatchString(path) {
+		proxy_checker.ReportFailure(proxyURL)
+	}
+}
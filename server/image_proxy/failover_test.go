@@ -0,0 +1,104 @@
+package image_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/proxy_checker"
+)
+
+func TestServeFailover(t *testing.T) {
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image from " + r.URL.Path))
+	}))
+	defer working.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	var unconfiguredHits atomic.Int32
+	unconfigured := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unconfiguredHits.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("internal"))
+	}))
+	defer unconfigured.Close()
+
+	config.GlobalConfig.ProxyList = []string{working.URL, broken.URL}
+	defer func() { config.GlobalConfig.ProxyList = nil }()
+
+	serve := func(preferred string, direct func(w http.ResponseWriter) error) (*httptest.ResponseRecorder, bool) {
+		directCalled := false
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/proxy/smart/img-master/1.jpg", nil)
+		err := ServeFailover(w, r, "img-master/1.jpg", preferred, func(w http.ResponseWriter) error {
+			directCalled = true
+			return direct(w)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, directCalled
+	}
+	directOK := func(w http.ResponseWriter) error {
+		w.Write([]byte("direct"))
+		return nil
+	}
+	directBroken := func(w http.ResponseWriter) error {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return nil
+	}
+
+	// no proxy is known to work yet
+	if resp, direct := serve(working.URL, directOK); direct || resp.Body.String() != "image from /img-master/1.jpg" {
+		t.Errorf("Expected preferred proxy to be used, got %q (direct: %v)", resp.Body.String(), direct)
+	}
+	if _, direct := serve(broken.URL, directOK); !direct {
+		t.Errorf("Expected to go direct when the preferred proxy is broken")
+	}
+	if resp, direct := serve("/proxy/i.pximg.net", directOK); !direct || resp.Body.String() != "direct" {
+		t.Errorf("Expected to go direct when the built-in proxy is preferred")
+	}
+	if resp, _ := serve(unconfigured.URL, directOK); unconfiguredHits.Load() != 0 || resp.Body.String() != "direct" {
+		t.Errorf("Expected a proxy entered by a user on a loopback address to be refused, got %q", resp.Body.String())
+	}
+
+	config.GlobalConfig.ProxyList = []string{working.URL}
+	proxy_checker.CheckProxies(context.Background())
+	if len(proxy_checker.GetWorkingProxies()) != 1 {
+		t.Fatal("Expected the working proxy to pass the check")
+	}
+
+	if resp, direct := serve("/proxy/i.pximg.net", directBroken); !direct || resp.Code != http.StatusOK || resp.Body.String() != "image from /img-master/1.jpg" {
+		t.Errorf("Expected to fail over from the built-in proxy to a working one, got %d %q", resp.Code, resp.Body.String())
+	}
+	if resp, _ := serve(broken.URL, directBroken); resp.Body.String() != "image from /img-master/1.jpg" {
+		t.Errorf("Expected to fail over from the preferred proxy to a working one, got %q", resp.Body.String())
+	}
+}
+
+func TestPximgImagePattern(t *testing.T) {
+	t.Parallel()
+	tests := map[string]bool{
+		"img-original/img/2024/01/21/20/50/51/115365120_p0.jpg":                           true,
+		"/c/250x250_80_a2/img-master/img/2024/01/21/20/50/51/115365120_p0_square1200.jpg": true,
+		"user-profile/img/2020/01/01/00/00/00/12345_abcdef_170.png":                       true,
+		"img-original/img/2024/01/21/20/50/51/../../../x.jpg":                             false,
+		"img-master/1.jpg":         false,
+		"slow?x=1":                 false,
+		"img-original/img/a/b.jpg": false,
+	}
+	for path, want := range tests {
+		if got := pximgImagePattern.MatchString(path); got != want {
+			t.Errorf("pximgImagePattern.MatchString(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package image_proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+const (
+	// maxCachedSize is the largest upstream response that will be stored in the cache, in bytes.
+	// Larger responses are streamed to the client without being cached.
+	maxCachedSize = 32 << 20
+
+	// defaultFreshness is used when upstream doesn't say how long a response can be cached
+	defaultFreshness = time.Hour
+	// maxHeuristicFreshness caps the freshness derived from Last-Modified
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+// Stats returns the image cache counters, and false if the cache isn't set up.
+func Stats() (CacheStats, bool) {
+	if cache == nil {
+		return CacheStats{}, false
+	}
+	return cache.Stats(), true
+}
+
+// CacheEnabled reports whether proxied images are cached on disk.
+func CacheEnabled() bool {
+	return cache != nil
+}
+
+// ServeCached serves an upstream image from the disk cache, fetching and storing it on a miss.
+// Expired entries are revalidated upstream with their ETag and Last-Modified.
+//
+// Responses that upstream marks as not storable, errors, and overly large responses are passed through without caching.
+func ServeCached(w http.ResponseWriter, r *http.Request, upstream *http.Request) error {
+	key := upstream.URL.String()
+	now := time.Now()
+
+	file, meta, cached := cache.Get(key)
+	if cached {
+		defer file.Close()
+		if meta.Fresh(now) {
+			serveEntry(w, r, file, meta)
+			return nil
+		}
+		if meta.ETag != "" {
+			upstream.Header.Set("If-None-Match", meta.ETag)
+		}
+		if !meta.ModTime.IsZero() {
+			upstream.Header.Set("If-Modified-Since", meta.ModTime.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := utils.HttpClient.Do(upstream)
+	if err != nil {
+		return i18n.Errorf("failed to proxy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	expires, storable := freshnessExpiry(resp.Header, now)
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		if storable {
+			meta.Expires = expires
+			if etag := resp.Header.Get("ETag"); etag != "" {
+				meta.ETag = etag
+			}
+			if err := cache.PutMetadata(key, meta); err != nil {
+				log.Printf("Failed to update cached image %s: %v", key, err)
+			}
+		}
+		serveEntry(w, r, file, meta)
+		return nil
+	}
+	if cached {
+		// upstream has a different version now, or the entry became unusable
+		cache.Remove(key)
+	}
+
+	if err := core.CheckProxyResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || !storable || resp.ContentLength > maxCachedSize {
+		return core.CopyProxyResponse(w, resp, resp.Body)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedSize+1))
+	if err != nil {
+		return i18n.Errorf("failed to read upstream image: %w", err)
+	}
+	if len(body) > maxCachedSize {
+		return core.CopyProxyResponse(w, resp, io.MultiReader(bytes.NewReader(body), resp.Body))
+	}
+
+	meta = Metadata{
+		ContentType: resp.Header.Get("Content-Type"),
+		ModTime:     now.UTC(),
+		ETag:        resp.Header.Get("ETag"),
+		Expires:     expires,
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		meta.ModTime = lastModified
+	}
+	if err := cache.Put(key, meta, body); err != nil {
+		log.Printf("Failed to cache image %s: %v", key, err)
+	}
+
+	serveEntry(w, r, bytes.NewReader(body), meta)
+	return nil
+}
+
+// serveEntry serves cached content. http.ServeContent sets Content-Length and Last-Modified,
+// and answers Range and conditional requests.
+func serveEntry(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, meta Metadata) {
+	header := w.Header()
+	if meta.ContentType != "" {
+		header.Set("Content-Type", meta.ContentType)
+	}
+	if meta.ETag != "" {
+		header.Set("ETag", meta.ETag)
+	}
+	maxAge := 365 * 24 * time.Hour
+	if !meta.Expires.IsZero() {
+		maxAge = max(time.Until(meta.Expires), 0)
+	}
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	http.ServeContent(w, r, "", meta.ModTime, content)
+}
+
+// freshnessExpiry determines until when a response can be served from the cache,
+// following the upstream Cache-Control and Expires headers.
+//
+// Returns false if the response must not be stored at all.
+func freshnessExpiry(header http.Header, now time.Time) (time.Time, bool) {
+	var maxAge, sharedMaxAge time.Duration = -1, -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "private":
+			return time.Time{}, false
+		case "no-cache":
+			// may be stored, but has to be revalidated every time
+			return now, true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				sharedMaxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	// we are a shared cache, so s-maxage takes precedence
+	if sharedMaxAge >= 0 {
+		return now.Add(sharedMaxAge), true
+	}
+	if maxAge >= 0 {
+		return now.Add(maxAge), true
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t, true
+		}
+		// an invalid Expires means already expired
+		return now, true
+	}
+	// heuristic freshness: a tenth of the time since the last modification
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && lastModified.Before(now) {
+		return now.Add(min(now.Sub(lastModified)/10, maxHeuristicFreshness)), true
+	}
+	return now.Add(defaultFreshness), true
+}
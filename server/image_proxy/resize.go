@@ -0,0 +1,254 @@
+// Package image_proxy implements the image handling done by the built-in image proxy (/proxy/i.pximg.net, /proxy/s.pximg.net),
+// such as downscaling images and caching them on disk.
+package image_proxy
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+const (
+	// maxSourceSize is the largest upstream image that will be resized, in bytes
+	maxSourceSize = 64 << 20
+	// maxSourcePixels guards against decompression bombs
+	maxSourcePixels = 100_000_000
+
+	defaultQuality = 90
+	minQuality     = 30
+	maxQuality     = 95
+)
+
+// allowedWidths are the widths an image can be resized to.
+// Requested widths are rounded up to one of these, so that the cache isn't filled with near-identical variants.
+var allowedWidths = []int{160, 240, 360, 480, 720, 1080, 1200, 1600, 2048, 2400, 3200}
+
+// ResizeOptions describes how an image should be resized and re-encoded.
+// A zero Width means the image is not downscaled; a zero Quality means defaultQuality.
+type ResizeOptions struct {
+	Width   int
+	Quality int
+}
+
+// Profiles are the bandwidth-saving presets a user can choose in /settings.
+var Profiles = map[string]ResizeOptions{
+	"balanced": {Width: 2048, Quality: 85},
+	"saver":    {Width: 1200, Quality: 60},
+}
+
+// cache holds proxied images as-is, resized images and rendered ugoira.
+// It is nil unless PIXIVFE_IMAGE_CACHE_ENABLED is set.
+var cache *DiskCache
+
+// Init sets up the on-disk image cache if it is enabled, and the cache of rendered ugoira.
+func Init() error {
+	var err error
+	if !config.GlobalConfig.ImageCacheEnabled {
+		ugoiraCache, err = NewDiskCache(filepath.Join(os.TempDir(), "pixivfe-ugoira"), ugoiraCacheSize)
+		if err != nil {
+			return i18n.Errorf("failed to create ugoira cache directory: %w", err)
+		}
+		return nil
+	}
+	cache, err = NewDiskCache(config.GlobalConfig.ImageCacheLocation, int64(config.GlobalConfig.ImageCacheSize)<<20)
+	if err != nil {
+		return i18n.Errorf("failed to create image cache directory: %w", err)
+	}
+	ugoiraCache = cache
+	return nil
+}
+
+// ParseResizeOptions reads the resize options of a request: the user's image profile cookie,
+// and the `w` (width) and `q` (quality) query parameters.
+//
+// The query parameters can only ask for a smaller or lower quality image than the profile allows,
+// so that pages can't undo the bandwidth savings the user chose. Without a profile, images are never resized.
+//
+// Returns false if the image should be passed through untouched.
+func ParseResizeOptions(r *http.Request) (ResizeOptions, bool) {
+	if !config.GlobalConfig.ImageResizeEnabled {
+		return ResizeOptions{}, false
+	}
+
+	opts, ok := Profiles[session.GetCookie(r, session.Cookie_ImageProfile)]
+	if !ok {
+		return opts, false
+	}
+
+	if width, err := strconv.Atoi(r.URL.Query().Get("w")); err == nil && width > 0 {
+		if opts.Width == 0 || width < opts.Width {
+			opts.Width = width
+		}
+	}
+	if quality, err := strconv.Atoi(r.URL.Query().Get("q")); err == nil && quality > 0 {
+		if opts.Quality == 0 || quality < opts.Quality {
+			opts.Quality = quality
+		}
+	}
+
+	return opts.normalize(), true
+}
+
+// ResizedURL appends a width hint to an image URL served by the built-in proxy,
+// for images that are displayed smaller than the user's image profile allows.
+// Images served by other proxies are returned unchanged, since those can't resize.
+func ResizedURL(url string, width int) string {
+	if !config.GlobalConfig.ImageResizeEnabled || !strings.HasPrefix(url, config.BuiltinProxyUrl+"/") {
+		return url
+	}
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%sw=%d", url, separator, width)
+}
+
+// normalize rounds the options to the values we are willing to produce.
+func (opts ResizeOptions) normalize() ResizeOptions {
+	if opts.Width > 0 {
+		width := allowedWidths[len(allowedWidths)-1]
+		for _, allowed := range allowedWidths {
+			if allowed >= opts.Width {
+				width = allowed
+				break
+			}
+		}
+		opts.Width = width
+	}
+	if opts.Quality == 0 {
+		opts.Quality = defaultQuality
+	}
+	opts.Quality = min(max(opts.Quality, minQuality), maxQuality)
+	opts.Quality = opts.Quality / 5 * 5
+	return opts
+}
+
+// ServeResized fetches an image from upstream and serves a downscaled, re-encoded version of it.
+// Results are cached on disk if the cache is enabled.
+//
+// Responses that aren't a resizable image (errors, animated GIFs, unsupported formats) are passed through as-is.
+func ServeResized(w http.ResponseWriter, r *http.Request, upstream *http.Request, opts ResizeOptions) error {
+	key := fmt.Sprintf("%s?w=%d&q=%d", upstream.URL.String(), opts.Width, opts.Quality)
+
+	if cache != nil {
+		if file, meta, ok := cache.Get(key); ok {
+			defer file.Close()
+			serveEntry(w, r, file, meta)
+			return nil
+		}
+	}
+
+	resp, err := utils.HttpClient.Do(upstream)
+	if err != nil {
+		return i18n.Errorf("failed to proxy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := core.CheckProxyResponse(resp); err != nil {
+		return err
+	}
+
+	source, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize+1))
+	if err != nil {
+		return i18n.Errorf("failed to read upstream image: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || len(source) > maxSourceSize {
+		return core.CopyProxyResponse(w, resp, bytes.NewReader(source))
+	}
+
+	resized, resizedType, err := Resize(source, opts)
+	if err != nil {
+		log.Printf("Not resizing %s: %v", upstream.URL.String(), err)
+		return core.CopyProxyResponse(w, resp, bytes.NewReader(source))
+	}
+
+	// resized images never expire, since Pixiv never changes an image without changing its URL
+	meta := Metadata{ContentType: resizedType, ModTime: time.Now().UTC()}
+	if cache != nil {
+		if err := cache.Put(key, meta, resized); err != nil {
+			log.Printf("Failed to cache resized image %s: %v", upstream.URL.String(), err)
+		}
+	}
+
+	serveEntry(w, r, bytes.NewReader(resized), meta)
+	return nil
+}
+
+// Resize decodes a JPEG, PNG or single-frame GIF image, downscales it to opts.Width if it is wider,
+// and re-encodes it. JPEG images are re-encoded as JPEG with opts.Quality; other formats as PNG.
+//
+// If re-encoding doesn't make the image smaller, the source is returned unchanged.
+func Resize(source []byte, opts ResizeOptions) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, "", fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	var img image.Image
+	switch format {
+	case "jpeg", "png":
+		img, _, err = image.Decode(bytes.NewReader(source))
+	case "gif":
+		var animation *gif.GIF
+		animation, err = gif.DecodeAll(bytes.NewReader(source))
+		if err == nil && len(animation.Image) > 1 {
+			return nil, "", fmt.Errorf("animated GIFs are not resized")
+		}
+		if err == nil {
+			img = animation.Image[0]
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported format: %s", format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.Width > 0 && opts.Width < cfg.Width {
+		height := max(cfg.Height*opts.Width/cfg.Width, 1)
+		dst := image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.Quality})
+	} else {
+		contentType = "image/png"
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if buf.Len() >= len(source) {
+		return source, "image/" + format, nil
+	}
+	return buf.Bytes(), contentType, nil
+}
@@ -0,0 +1,117 @@
+package image_proxy
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    ResizeOptions
+		expected ResizeOptions
+	}{
+		{"Round width up", ResizeOptions{Width: 1000, Quality: 80}, ResizeOptions{Width: 1080, Quality: 80}},
+		{"Cap width", ResizeOptions{Width: 100000, Quality: 80}, ResizeOptions{Width: 3200, Quality: 80}},
+		{"No width", ResizeOptions{Quality: 60}, ResizeOptions{Quality: 60}},
+		{"Default quality", ResizeOptions{Width: 240}, ResizeOptions{Width: 240, Quality: defaultQuality}},
+		{"Clamp quality", ResizeOptions{Width: 240, Quality: 5}, ResizeOptions{Width: 240, Quality: minQuality}},
+		{"Round quality", ResizeOptions{Width: 240, Quality: 77}, ResizeOptions{Width: 240, Quality: 75}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.input.normalize(); got != tt.expected {
+				t.Errorf("normalize() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResizeOptions(t *testing.T) {
+	config.GlobalConfig.ImageResizeEnabled = true
+	defer func() { config.GlobalConfig.ImageResizeEnabled = false }()
+
+	tests := []struct {
+		name     string
+		profile  string
+		query    string
+		expected ResizeOptions
+		resize   bool
+	}{
+		{"Original", "", "", ResizeOptions{}, false},
+		{"Original ignores width", "", "?w=240", ResizeOptions{}, false},
+		{"Profile", "saver", "", ResizeOptions{Width: 1200, Quality: 60}, true},
+		{"Profile caps width", "saver", "?w=2048&q=95", ResizeOptions{Width: 1200, Quality: 60}, true},
+		{"Smaller than profile", "saver", "?w=240&q=40", ResizeOptions{Width: 240, Quality: 40}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/proxy/i.pximg.net/img.jpg"+tt.query, nil)
+			if tt.profile != "" {
+				r.AddCookie(&http.Cookie{Name: string(session.Cookie_ImageProfile), Value: tt.profile})
+			}
+			got, resize := ParseResizeOptions(r)
+			if got != tt.expected || resize != tt.resize {
+				t.Errorf("ParseResizeOptions() = %+v, %v, want %+v, %v", got, resize, tt.expected, tt.resize)
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		for y := 0; y < 400; y++ {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	resized, contentType, err := Resize(buf.Bytes(), ResizeOptions{Width: 240, Quality: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(resized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 240 || cfg.Height != 120 {
+		t.Errorf("Expected 240x120, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if _, _, err := Resize([]byte("not an image"), ResizeOptions{Width: 240}); err == nil {
+		t.Errorf("Expected error for invalid image")
+	}
+}
+
+func TestResizedURL(t *testing.T) {
+	config.GlobalConfig.ImageResizeEnabled = true
+	defer func() { config.GlobalConfig.ImageResizeEnabled = false }()
+
+	for url, expected := range map[string]string{
+		"/proxy/i.pximg.net/img-original/img/1.jpg":     "/proxy/i.pximg.net/img-original/img/1.jpg?w=360",
+		"/proxy/i.pximg.net/img-original/img/1.jpg?a=b": "/proxy/i.pximg.net/img-original/img/1.jpg?a=b&w=360",
+		"https://proxy.example/img-original/img/1.jpg":  "https://proxy.example/img-original/img/1.jpg",
+	} {
+		if got := ResizedURL(url, 360); got != expected {
+			t.Errorf("ResizedURL(%q) = %q, want %q", url, got, expected)
+		}
+	}
+}
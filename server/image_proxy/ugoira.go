@@ -0,0 +1,397 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+)
+
+const (
+	// maxUgoiraFrames and maxUgoiraPixels limit the work done for a single ugoira.
+	// All decoded frames are held in memory at once, at up to 4 bytes per pixel.
+	maxUgoiraFrames = 1000
+	maxUgoiraPixels = 50_000_000
+
+	// maxUgoiraArchiveSize caps the original frame archive offered for download, in bytes.
+	// It applies even when PIXIVFE_PROXY_MAX_BODY_SIZE is 0.
+	maxUgoiraArchiveSize = 1 << 30
+
+	// maxConcurrentUgoiraRenders limits how many ugoira are decoded and encoded at the same time
+	maxConcurrentUgoiraRenders = 2
+
+	// ugoiraRenderTimeout bounds fetching and encoding an ugoira
+	ugoiraRenderTimeout = 2 * time.Minute
+
+	// ugoiraCacheSize caps the cache of rendered ugoira that is used when the image cache is disabled, in bytes
+	ugoiraCacheSize = 256 << 20
+)
+
+// UgoiraFormats maps the supported ugoira output formats to their content types.
+// WebP is missing since neither the standard library nor golang.org/x/image can encode it.
+var UgoiraFormats = map[string]string{
+	"gif": "image/gif",
+	"png": "image/apng",
+}
+
+type ugoiraRender struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	// ugoiraCache holds rendered ugoira, since rendering them is expensive.
+	// It is the image cache if that is enabled, and a small cache in the temporary directory otherwise.
+	// It is nil until Init is called.
+	ugoiraCache *DiskCache
+
+	// ugoiraRenders deduplicates concurrent renders of the same ugoira
+	ugoiraRenders      = make(map[string]*ugoiraRender)
+	ugoiraRendersMutex sync.Mutex
+
+	// ugoiraRenderSlots is a semaphore for maxConcurrentUgoiraRenders
+	ugoiraRenderSlots = make(chan struct{}, maxConcurrentUgoiraRenders)
+)
+
+// ServeUgoira serves the ugoira with the given ID as an animated image in format (one of UgoiraFormats).
+//
+// The frames are fetched from Pixiv and assembled on the server. Results are cached on disk in ugoiraCache.
+func ServeUgoira(w http.ResponseWriter, r *http.Request, id, format string) error {
+	contentType, ok := UgoiraFormats[format]
+	if !ok {
+		return i18n.Errorf("Unsupported ugoira format: %s", format)
+	}
+
+	key := fmt.Sprintf("ugoira:%s.%s", id, format)
+	if ugoiraCache != nil {
+		if file, meta, ok := ugoiraCache.Get(key); ok {
+			defer file.Close()
+			serveEntry(w, r, file, meta)
+			return nil
+		}
+	}
+
+	data, err := renderUgoiraOnce(r, key, id, format)
+	if err != nil {
+		return err
+	}
+
+	// ugoira never change, so the result doesn't expire
+	meta := Metadata{ContentType: contentType, ModTime: time.Now().UTC()}
+	if ugoiraCache != nil {
+		if err := ugoiraCache.Put(key, meta, data); err != nil {
+			log.Printf("Failed to cache ugoira %s: %v", id, err)
+		}
+	}
+
+	serveEntry(w, r, bytes.NewReader(data), meta)
+	return nil
+}
+
+// renderUgoiraOnce renders an ugoira, or waits for a render of the same ugoira that is already in progress.
+func renderUgoiraOnce(r *http.Request, key, id, format string) ([]byte, error) {
+	ugoiraRendersMutex.Lock()
+	if render, ok := ugoiraRenders[key]; ok {
+		ugoiraRendersMutex.Unlock()
+		select {
+		case <-render.done:
+			return render.data, render.err
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+	render := &ugoiraRender{done: make(chan struct{})}
+	ugoiraRenders[key] = render
+	ugoiraRendersMutex.Unlock()
+
+	// the render is shared, so it shouldn't be cancelled when this particular client goes away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), ugoiraRenderTimeout)
+	defer cancel()
+	render.data, render.err = renderUgoira(r.WithContext(ctx), id, format)
+
+	ugoiraRendersMutex.Lock()
+	delete(ugoiraRenders, key)
+	ugoiraRendersMutex.Unlock()
+	close(render.done)
+
+	return render.data, render.err
+}
+
+func renderUgoira(r *http.Request, id, format string) ([]byte, error) {
+	meta, err := core.GetUgoiraMeta(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Frames) > maxUgoiraFrames {
+		return nil, i18n.Errorf("Ugoira %s has too many frames: %d", id, len(meta.Frames))
+	}
+
+	archive, err := FetchUgoiraArchive(r.Context(), meta.Src, maxSourceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ugoiraRenderSlots <- struct{}{}:
+		defer func() { <-ugoiraRenderSlots }()
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+
+	frames, delays, err := decodeUgoiraFrames(archive, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		err = encodeAPNG(&buf, frames, delays)
+	default:
+		err = encodeGIF(&buf, frames, delays)
+	}
+	if err != nil {
+		return nil, i18n.Errorf("Failed to encode ugoira %s: %w", id, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FetchUgoiraArchive downloads an ugoira frame ZIP of at most maxSize bytes from i.pximg.net.
+func FetchUgoiraArchive(ctx context.Context, url string, maxSize int64) (*zip.Reader, error) {
+	body, err := fetchUgoiraArchive(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, i18n.Errorf("failed to fetch ugoira frames: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, i18n.Error("Ugoira frame archive is too large")
+	}
+
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// spoolUgoiraArchive downloads an ugoira frame ZIP of at most maxSize bytes from i.pximg.net into a temporary file,
+// since reading a ZIP needs random access. The caller must close and remove the file.
+func spoolUgoiraArchive(ctx context.Context, url string, maxSize int64) (*os.File, int64, error) {
+	body, err := fetchUgoiraArchive(ctx, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+
+	file, err := os.CreateTemp("", "pixivfe-ugoira-*.zip")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := io.Copy(file, io.LimitReader(body, maxSize+1))
+	if err == nil && size > maxSize {
+		err = i18n.Error("Ugoira frame archive is too large")
+	} else if err != nil {
+		err = i18n.Errorf("failed to fetch ugoira frames: %w", err)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+func fetchUgoiraArchive(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Referer", "https://www.pixiv.net/")
+
+	resp, err := utils.HttpClient.Do(req)
+	if err != nil {
+		return nil, i18n.Errorf("failed to fetch ugoira frames: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, i18n.Errorf("failed to fetch ugoira frames: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ServeUgoiraArchive serves the original resolution frames of an ugoira as a ZIP,
+// together with an animation.json listing the frames and their delays (the format gallery-dl uses).
+//
+// The frames are copied from Pixiv's archive as-is, without recompressing them. Pixiv's archive is kept
+// in a temporary file while the new one is streamed to the client, so neither is held in memory.
+func ServeUgoiraArchive(w http.ResponseWriter, r *http.Request, id string) error {
+	meta, err := core.GetUgoiraMeta(r, id)
+	if err != nil {
+		return err
+	}
+
+	maxSize := int64(maxUgoiraArchiveSize)
+	if limit := int64(config.GlobalConfig.ProxyMaxBodySize) << 20; limit > 0 {
+		maxSize = min(maxSize, limit)
+	}
+	file, size, err := spoolUgoiraArchive(r.Context(), meta.OriginalSrc, maxSize)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return i18n.Errorf("failed to read ugoira frames: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_ugoira.zip"`, id))
+	return writeUgoiraArchive(w, archive, meta.Frames)
+}
+
+func writeUgoiraArchive(w io.Writer, archive *zip.Reader, frames []core.UgoiraFrame) error {
+	animation, err := json.MarshalIndent(frames, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out := zip.NewWriter(w)
+	for _, file := range archive.File {
+		if err := out.Copy(file); err != nil {
+			return i18n.Errorf("failed to write ugoira archive: %w", err)
+		}
+	}
+
+	file, err := out.CreateHeader(&zip.FileHeader{Name: "animation.json", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return i18n.Errorf("failed to write ugoira archive: %w", err)
+	}
+	if _, err := file.Write(animation); err != nil {
+		return i18n.Errorf("failed to write ugoira archive: %w", err)
+	}
+
+	return out.Close()
+}
+
+// decodeUgoiraFrames decodes the frames listed in meta from the frame archive, in order.
+func decodeUgoiraFrames(archive *zip.Reader, meta core.UgoiraMeta) ([]image.Image, []time.Duration, error) {
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	frames := make([]image.Image, 0, len(meta.Frames))
+	delays := make([]time.Duration, 0, len(meta.Frames))
+	var bounds image.Rectangle
+	totalPixels := 0
+
+	for _, frame := range meta.Frames {
+		file, ok := files[frame.File]
+		if !ok {
+			return nil, nil, i18n.Errorf("Ugoira frame missing from archive: %s", frame.File)
+		}
+
+		img, err := decodeZipImage(file)
+		if err != nil {
+			return nil, nil, i18n.Errorf("Failed to decode ugoira frame %s: %w", frame.File, err)
+		}
+
+		if len(frames) == 0 {
+			bounds = img.Bounds()
+		} else if img.Bounds() != bounds {
+			// all frames need the same size; this shouldn't happen with Pixiv's archives
+			normalized := image.NewNRGBA(bounds)
+			draw.Draw(normalized, bounds, img, img.Bounds().Min, draw.Src)
+			img = normalized
+		}
+
+		totalPixels += bounds.Dx() * bounds.Dy()
+		if totalPixels > maxUgoiraPixels {
+			return nil, nil, i18n.Error("Ugoira is too large")
+		}
+
+		frames = append(frames, img)
+		delays = append(delays, time.Duration(frame.Delay)*time.Millisecond)
+	}
+	return frames, delays, nil
+}
+
+func decodeZipImage(file *zip.File) (image.Image, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("frame too large")
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("frame too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" {
+		// PNG frames may use different palettes or color types, which APNG can't mix
+		normalized := image.NewNRGBA(img.Bounds())
+		draw.Draw(normalized, img.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = normalized
+	}
+	return img, nil
+}
+
+// encodeGIF encodes frames as a GIF that loops forever, dithering every frame to the Plan 9 palette.
+func encodeGIF(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	animation := &gif.GIF{
+		Image:     make([]*image.Paletted, len(frames)),
+		Delay:     make([]int, len(frames)),
+		LoopCount: 0,
+	}
+
+	for i, frame := range frames {
+		bounds := frame.Bounds()
+		paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, bounds.Min)
+		animation.Image[i] = paletted
+
+		// GIF delays are in 1/100s. browsers slow down delays below 2/100s, so don't go below that
+		animation.Delay[i] = max(int(delays[i]/(10*time.Millisecond)), 2)
+	}
+
+	return gif.EncodeAll(w, animation)
+}
@@ -0,0 +1,175 @@
+package image_proxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+)
+
+func testUgoira(t *testing.T, frameCount int) (*zip.Reader, core.UgoiraMeta) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	var meta core.UgoiraMeta
+
+	for i := 0; i < frameCount; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 48; y++ {
+				img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), uint8(i * 80), 255})
+			}
+		}
+		name := fmt.Sprintf("%06d.jpg", i)
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := jpeg.Encode(file, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		meta.Frames = append(meta.Frames, core.UgoiraFrame{File: name, Delay: 100})
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader, meta
+}
+
+func TestUgoiraGIF(t *testing.T) {
+	archive, meta := testUgoira(t, 3)
+	frames, delays, err := decodeUgoiraFrames(archive, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeGIF(&buf, frames, delays); err != nil {
+		t.Fatal(err)
+	}
+
+	animation, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 3 || animation.Delay[0] != 10 {
+		t.Errorf("Expected 3 frames of 10/100s, got %d frames, delays %v", len(animation.Image), animation.Delay)
+	}
+	if animation.Config.Width != 64 || animation.Config.Height != 48 {
+		t.Errorf("Expected 64x48, got %dx%d", animation.Config.Width, animation.Config.Height)
+	}
+}
+
+func TestUgoiraAPNG(t *testing.T) {
+	archive, meta := testUgoira(t, 3)
+	frames, delays, err := decodeUgoiraFrames(archive, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeAPNG(&buf, frames, delays); err != nil {
+		t.Fatal(err)
+	}
+
+	// decoders without APNG support show the first frame
+	if _, err := png.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Expected a valid PNG: %v", err)
+	}
+
+	chunks, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, chunk := range chunks {
+		counts[chunk.kind]++
+		if chunk.kind == "acTL" && binary.BigEndian.Uint32(chunk.data) != 3 {
+			t.Errorf("Expected acTL to announce 3 frames")
+		}
+	}
+	if counts["fcTL"] != 3 || counts["fdAT"] < 2 || counts["IEND"] != 1 {
+		t.Errorf("Unexpected chunks: %v", counts)
+	}
+}
+
+func TestUgoiraMissingFrame(t *testing.T) {
+	archive, meta := testUgoira(t, 1)
+	meta.Frames = append(meta.Frames, core.UgoiraFrame{File: "missing.jpg", Delay: 100})
+	if _, _, err := decodeUgoiraFrames(archive, meta); err == nil {
+		t.Errorf("Expected error for missing frame")
+	}
+}
+
+func TestUgoiraArchive(t *testing.T) {
+	archive, meta := testUgoira(t, 2)
+
+	var buf bytes.Buffer
+	if err := writeUgoiraArchive(&buf, archive, meta.Frames); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range out.File {
+		names = append(names, file.Name)
+	}
+	if len(names) != 3 || names[2] != "animation.json" {
+		t.Fatalf("Unexpected files: %v", names)
+	}
+
+	reader, err := out.File[2].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var frames []core.UgoiraFrame
+	if err := json.NewDecoder(reader).Decode(&frames); err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].File != "000000.jpg" || frames[0].Delay != 100 {
+		t.Errorf("Unexpected animation.json: %+v", frames)
+	}
+}
+
+func TestSpoolUgoiraArchive(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	if _, _, err := spoolUgoiraArchive(context.Background(), server.URL, 50); err == nil {
+		t.Errorf("Expected an archive over the size limit to be refused")
+	}
+
+	file, size, err := spoolUgoiraArchive(context.Background(), server.URL, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if size != 100 {
+		t.Errorf("Expected 100 bytes, got %d", size)
+	}
+}
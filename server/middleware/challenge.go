@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/routes"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
+)
+
+// challengePath is where solved challenges are submitted to
+const challengePath = "/challenge"
+
+// challengeMaxAge is how long a client has to solve a challenge
+const challengeMaxAge = 5 * time.Minute
+
+// sign returns the hex-encoded HMAC of payload, keyed with the configured challenge secret.
+// kind separates challenges from clearances, so that one can never be used as the other.
+func sign(kind, payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.GlobalConfig.ChallengeSecret))
+	mac.Write([]byte(kind + "|" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySignature(kind, payload, signature string) bool {
+	return hmac.Equal([]byte(sign(kind, payload)), []byte(signature))
+}
+
+// newChallenge creates a signed challenge in the format "timestamp.random.signature".
+// The challenge is stateless: the server doesn't need to remember which challenges it handed out.
+func newChallenge(now time.Time) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	payload := strconv.FormatInt(now.Unix(), 10) + "." + hex.EncodeToString(random)
+	return payload + "." + sign("challenge", payload), nil
+}
+
+// verifyChallenge checks that the challenge was issued by us and has not expired.
+func verifyChallenge(challenge string, now time.Time) bool {
+	payload, signature, found := cutLast(challenge, ".")
+	if !found || !verifySignature("challenge", payload, signature) {
+		return false
+	}
+	timestamp, _, _ := strings.Cut(payload, ".")
+	issued, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(issued, 0))
+	return age >= 0 && age <= challengeMaxAge
+}
+
+// verifySolution checks that SHA-256(challenge + nonce) has at least difficulty leading zero bits.
+func verifySolution(challenge, nonce string, difficulty int) bool {
+	if nonce == "" || len(nonce) > 32 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+func leadingZeroBits(b []byte) int {
+	count := 0
+	for _, x := range b {
+		if x != 0 {
+			return count + bits.LeadingZeros8(x)
+		}
+		count += 8
+	}
+	return count
+}
+
+// newClearance creates a clearance cookie value in the format "expiry.signature".
+// The signature covers the User-Agent, so a clearance can't simply be shared between different clients.
+func newClearance(r *http.Request, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sign("clearance", payload+"|"+r.UserAgent())
+}
+
+// verifyClearance checks that the clearance cookie was issued by us for this client and has not expired.
+func verifyClearance(r *http.Request, value string, now time.Time) bool {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found || !verifySignature("clearance", payload+"|"+r.UserAgent(), signature) {
+		return false
+	}
+	expires, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.Before(time.Unix(expires, 0))
+}
+
+// IsExemptFromChallenge determines if a request can skip the proof-of-work challenge.
+//
+// A request is exempt when its path matches PIXIVFE_CHALLENGE_EXEMPT_PATHS (as a prefix or a path.Match pattern),
+// its User-Agent contains one of PIXIVFE_CHALLENGE_ALLOWED_USER_AGENTS, or it comes from one of PIXIVFE_CHALLENGE_ALLOWED_IPS.
+func IsExemptFromChallenge(r *http.Request) bool {
+	p := r.URL.Path
+	if p == challengePath {
+		return true
+	}
+	for _, pattern := range config.GlobalConfig.ChallengeExemptPaths {
+		if strings.HasPrefix(p, pattern) {
+			return true
+		}
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+
+	userAgent := strings.ToLower(r.UserAgent())
+	for _, allowed := range config.GlobalConfig.ChallengeAllowedUserAgents {
+		if allowed != "" && strings.Contains(userAgent, strings.ToLower(allowed)) {
+			return true
+		}
+	}
+
+	ip := clientIP(r)
+	return ip != nil && ipInList(ip, config.GlobalConfig.ChallengeAllowedIPs)
+}
+
+// ipInList reports whether ip is one of the IP addresses or CIDR ranges in list.
+func ipInList(ip net.IP, list []string) bool {
+	for _, entry := range list {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client.
+//
+// X-Forwarded-For can be sent by anyone, so it is only used when the request comes from one of
+// PIXIVFE_CHALLENGE_TRUSTED_PROXIES. Then the last address in it that isn't a trusted proxy is the client.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ipInList(ip, config.GlobalConfig.ChallengeTrustedProxies) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !ipInList(ip, config.GlobalConfig.ChallengeTrustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// ChallengeScrapers is a middleware that serves a proof-of-work challenge to clients without a valid clearance cookie.
+// Solving the challenge (see VerifyChallenge) sets the clearance cookie.
+//
+// Does nothing unless PIXIVFE_CHALLENGE_ENABLED is set.
+func ChallengeScrapers(h http.Handler) http.Handler {
+	if !config.GlobalConfig.ChallengeEnabled {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsExemptFromChallenge(r) ||
+			verifyClearance(r, session.GetCookie(r, session.Cookie_Clearance), time.Now()) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		redirect := "/"
+		if r.Method == http.MethodGet {
+			redirect = r.URL.RequestURI()
+		}
+		serveChallenge(w, r, redirect)
+	})
+}
+
+func serveChallenge(w http.ResponseWriter, r *http.Request, redirect string) {
+	challenge, err := newChallenge(time.Now())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	routes.ChallengePage(w, r, challenge, config.GlobalConfig.ChallengeDifficulty, redirect)
+}
+
+// VerifyChallenge checks a submitted challenge solution.
+// On success, it sets the clearance cookie and redirects to the page the client originally requested.
+// On failure, a new challenge is served.
+func VerifyChallenge(w http.ResponseWriter, r *http.Request) {
+	redirect := safeRedirect(r.FormValue("redirect"))
+	challenge := r.FormValue("challenge")
+	nonce := r.FormValue("nonce")
+
+	now := time.Now()
+	if !config.GlobalConfig.ChallengeEnabled ||
+		!verifyChallenge(challenge, now) ||
+		!verifySolution(challenge, nonce, config.GlobalConfig.ChallengeDifficulty) {
+		serveChallenge(w, r, redirect)
+		return
+	}
+
+	expires := now.Add(config.GlobalConfig.ChallengeClearanceDuration)
+	http.SetCookie(w, &http.Cookie{
+		Name:     string(session.Cookie_Clearance),
+		Value:    newClearance(r, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		// Lax instead of Strict, otherwise following a link to PixivFE from another site would show the challenge again
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// safeRedirect only allows redirects to a path on this site, preventing open redirects.
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+)
+
+func TestChallenge(t *testing.T) {
+	config.GlobalConfig.ChallengeSecret = "test-secret"
+	now := time.Now()
+
+	challenge, err := newChallenge(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !verifyChallenge(challenge, now) {
+		t.Errorf("Expected fresh challenge to be valid")
+	}
+	if verifyChallenge(challenge, now.Add(challengeMaxAge+time.Second)) {
+		t.Errorf("Expected expired challenge to be invalid")
+	}
+	if verifyChallenge(challenge+"0", now) {
+		t.Errorf("Expected tampered challenge to be invalid")
+	}
+
+	// Brute force a solution the same way challenge.js does
+	difficulty := 8
+	nonce := 0
+	for !verifySolution(challenge, strconv.Itoa(nonce), difficulty) {
+		nonce++
+	}
+	if verifySolution(challenge, "", difficulty) {
+		t.Errorf("Expected empty nonce to be rejected")
+	}
+}
+
+func TestClearance(t *testing.T) {
+	config.GlobalConfig.ChallengeSecret = "test-secret"
+	now := time.Now()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	value := newClearance(r, now.Add(time.Hour))
+
+	if !verifyClearance(r, value, now) {
+		t.Errorf("Expected clearance to be valid")
+	}
+	if verifyClearance(r, value, now.Add(2*time.Hour)) {
+		t.Errorf("Expected expired clearance to be invalid")
+	}
+
+	other := httptest.NewRequest("GET", "/", nil)
+	other.Header.Set("User-Agent", "curl/8.0")
+	if verifyClearance(other, value, now) {
+		t.Errorf("Expected clearance to be bound to the User-Agent")
+	}
+}
+
+func TestIsExemptFromChallenge(t *testing.T) {
+	config.GlobalConfig.ChallengeExemptPaths = []string{"/css/", "/users/*.atom.xml"}
+	config.GlobalConfig.ChallengeAllowedUserAgents = []string{"FeedFetcher"}
+	config.GlobalConfig.ChallengeAllowedIPs = []string{"10.0.0.0/8", "192.0.2.1"}
+	config.GlobalConfig.ChallengeTrustedProxies = []string{"198.51.100.1"}
+	defer func() { config.GlobalConfig.ChallengeTrustedProxies = nil }()
+
+	tests := []struct {
+		name       string
+		path       string
+		userAgent  string
+		remoteAddr string
+		forwarded  string
+		expected   bool
+	}{
+		{"Prefix", "/css/style.css", "", "203.0.113.1:1234", "", true},
+		{"Pattern", "/users/123.atom.xml", "", "203.0.113.1:1234", "", true},
+		{"Pattern does not cross segments", "/users/123/illustrations.atom.xml", "", "203.0.113.1:1234", "", false},
+		{"User agent", "/", "Mozilla/5.0 feedfetcher", "203.0.113.1:1234", "", true},
+		{"CIDR", "/", "", "10.1.2.3:1234", "", true},
+		{"IP", "/", "", "192.0.2.1:1234", "", true},
+		{"Not exempt", "/artworks/1", "", "203.0.113.1:1234", "", false},
+		{"Forwarded by an untrusted peer", "/", "", "203.0.113.1:1234", "192.0.2.1", false},
+		{"Forwarded by a trusted proxy", "/", "", "198.51.100.1:1234", "192.0.2.1", true},
+		{"Spoofed before a trusted proxy", "/", "", "198.51.100.1:1234", "192.0.2.1, 203.0.113.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := IsExemptFromChallenge(r); got != tt.expected {
+				t.Errorf("IsExemptFromChallenge(%s) = %v, want %v", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	for target, expected := range map[string]string{
+		"/artworks/1?a=b":      "/artworks/1?a=b",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+		"":                     "/",
+	} {
+		if got := safeRedirect(target); got != expected {
+			t.Errorf("safeRedirect(%q) = %q, want %q", target, got, expected)
+		}
+	}
+}
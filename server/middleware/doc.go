@@ -4,8 +4,9 @@ It defines various middleware functions, request handlers, and routing logic to
 incoming HTTP requests and produce appropriate responses.
 
 The package includes middleware for security headers (SetPrivacyHeaders), error catching
-and handling (CatchError, HandleError), rate limiting (IPRateLimiter, RateLimitRequest), logging (LogRequest),
-panic recovery (RecoverFromPanic), and user context injection (ProvideUserContext). These
+and handling (CatchError, HandleError), per route class rate limiting (NewIPRateLimiter, ClassifyRequest, RateLimitRequest), logging (LogRequest),
+panic recovery (RecoverFromPanic), proof-of-work challenges against scrapers (ChallengeScrapers),
+and user context injection (ProvideUserContext). These
 middlewares can be applied to routes to add cross-cutting functionality across multiple endpoints.
 
 Route definitions are centralized in the DefineRoutes function, which sets up all paths
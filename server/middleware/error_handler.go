@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"bytes"
+	"errors"
+	"log"
 	"maps"
 	"net/http"
 	"net/http/httptest"
@@ -43,6 +45,49 @@ func CatchError(handler func(w http.ResponseWriter, r *http.Request) error) http
 	}
 }
 
+// CatchStreamError is like CatchError, but doesn't buffer the response, for handlers that stream large bodies.
+// An error is only turned into an error page if the handler hasn't written anything yet; otherwise it is logged,
+// and the client receives a truncated response.
+func CatchStreamError(handler func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Backup the original response headers, since handlers set e.g. Content-Disposition before they write anything
+		header_backup := http.Header{}
+		for k, v := range w.Header() {
+			header_backup[k] = slices.Clone(v)
+		}
+
+		writer := &trackingWriter{ResponseWriter: w}
+		err := handler(writer, r)
+		if err == nil {
+			return
+		}
+		if writer.written {
+			log.Printf("Error after response was started for %s: %v", r.URL.Path, err)
+			return
+		}
+		// Restore the original headers, so that the error page isn't served as a download
+		clear(w.Header())
+		maps.Copy(w.Header(), header_backup)
+		request_context.Get(r).CaughtError = err
+	}
+}
+
+// trackingWriter records whether a response has been started.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trackingWriter) WriteHeader(statusCode int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
 // HandleError is a middleware that checks for errors caught by CatchError and renders an error page if necessary.
 func HandleError(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +96,10 @@ func HandleError(h http.Handler) http.Handler {
 
 		// Check if an error was caught during the request processing
 		err := request_context.Get(r).CaughtError
-		if err != nil {
+		var rateLimited *routes.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			routes.RateLimitedPage(w, r, rateLimited.Class, rateLimited.RetryAfter)
+		} else if err != nil {
 			// If an error was caught, render the error page
 			routes.ErrorPage(w, r, err, http.StatusInternalServerError)
 		}
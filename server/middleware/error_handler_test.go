@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
+)
+
+func TestCatchStreamError(t *testing.T) {
+	handler := CatchStreamError(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="1.zip"`)
+		return errors.New("upstream failed")
+	})
+
+	r := httptest.NewRequest("GET", "/artworks/1/download.zip", nil)
+	r = r.WithContext(request_context.ProvideWith(context.Background()))
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Kept", "1")
+	handler(w, r)
+
+	if request_context.Get(r).CaughtError == nil {
+		t.Fatal("Expected the error to be caught")
+	}
+	if w.Header().Get("Content-Type") != "" || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("Expected the download headers to be removed, got %v", w.Header())
+	}
+	if w.Header().Get("X-Kept") != "1" {
+		t.Errorf("Expected headers set before the handler to be kept, got %v", w.Header())
+	}
+}
@@ -1,68 +1,166 @@
 package middleware
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/sethvargo/go-limiter"
 	"github.com/sethvargo/go-limiter/httplimit"
 	"github.com/sethvargo/go-limiter/memorystore"
 
 	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
+	"codeberg.org/vnpower/pixivfe/v2/server/routes"
 )
 
+// limiterInterval is the interval until tokens reset for every route class.
+const limiterInterval = 30 * time.Second
+
 // CanRequestSkipLimiter determines if a request should bypass the rate limiter.
-// It exempts static assets and proxied image requests from rate limiting.
+// It exempts static assets from rate limiting.
 func CanRequestSkipLimiter(r *http.Request) bool {
 	path := r.URL.Path
 	return strings.HasPrefix(path, "/img/") ||
 		strings.HasPrefix(path, "/css/") ||
-		strings.HasPrefix(path, "/js/") ||
-		strings.HasPrefix(path, "/proxy/s.pximg.net/")
+		strings.HasPrefix(path, "/js/")
+}
+
+// artworkFilePattern matches the routes that serve the image files of an artwork rather than a page,
+// like /artworks/{id}/ugoira.gif
+var artworkFilePattern = regexp.MustCompile(`^/artworks/\d+/ugoira\.(gif|png|zip)$`)
+
+// ClassifyRequest determines the route class of a request, which decides the
+// rate limit budget it takes tokens from.
+func ClassifyRequest(r *http.Request) routes.RouteClass {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/proxy/"):
+		return routes.RouteClassProxy
+	case artworkFilePattern.MatchString(path):
+		return routes.RouteClassProxy
+	case strings.HasPrefix(path, "/artworks-multi/"):
+		return routes.RouteClassMulti
+	case path == "/tags" || strings.HasPrefix(path, "/tags/"):
+		return routes.RouteClassSearch
+	case r.Method == http.MethodPost &&
+		(strings.HasPrefix(path, "/self/") || strings.HasPrefix(path, "/settings/")):
+		return routes.RouteClassAction
+	default:
+		return routes.RouteClassPage
+	}
 }
 
-// NewIPRateLimiter creates a new instance of IPRateLimiter with the specified rate limit and burst.
+// RequestCost returns the number of tokens a request takes from its route class before it is handled.
+//
+// Most requests cost one token. /artworks-multi/{ids} costs one token per artwork,
+// since each artwork results in its own set of upstream API calls.
+// Archive downloads are weighted by their number of pages once the handler knows it, with routes.ChargeRequest.
+//
+// The middleware caps the cost at the budget of the route class, so that a request can always go through on a full bucket.
+func RequestCost(r *http.Request, class routes.RouteClass) uint64 {
+	if class != routes.RouteClassMulti {
+		return 1
+	}
+	ids := strings.TrimPrefix(r.URL.Path, "/artworks-multi/")
+	cost := uint64(0)
+	for _, id := range strings.Split(ids, ",") {
+		if id != "" {
+			cost++
+		}
+	}
+	return max(cost, 1)
+}
+
+// NewIPRateLimiter creates a new store that limits requests per IP with the specified rate limit.
 //
 // ## Arguments
 //
 // Tokens: Number of tokens allowed per interval.
 // Interval: Interval until tokens reset.
-func NewIPRateLimiter(Tokens uint64, Interval time.Duration) (*httplimit.Middleware, error) {
-	store, err := memorystore.New(&memorystore.Config{
+func NewIPRateLimiter(Tokens uint64, Interval time.Duration) (limiter.Store, error) {
+	return memorystore.New(&memorystore.Config{
 		Tokens:   Tokens,
 		Interval: Interval,
 	})
-	if err != nil {
-		return nil, err
-	}
-	return httplimit.NewMiddleware(store, httplimit.IPKeyFunc("X-Forwarded-For"))
 }
 
-// Global rate limiter instance
-var limiter *httplimit.Middleware
+// Global rate limiter stores and their number of tokens, one per route class
+var (
+	limiters map[routes.RouteClass]limiter.Store
+	budgets  map[routes.RouteClass]uint64
+)
+
+// limiterKey identifies the client that a request is made by
+var limiterKey = httplimit.IPKeyFunc("X-Forwarded-For")
 
-// InitializeRateLimiter sets up the global rate limiter based on the application's configuration.
+// InitializeRateLimiter sets up the global rate limiters based on the application's configuration.
 // If the request limit is less than 1, it sets an infinite rate limit.
 //
 // Returns the rate limit middleware
 func InitializeRateLimiter() func(http.Handler) http.Handler {
 	if config.GlobalConfig.RequestLimit < 1 {
-		limiter = nil
-	} else {
-		var err error
-		limiter, err = NewIPRateLimiter(config.GlobalConfig.RequestLimit, 30*time.Second)
+		limiters = nil
+		return rateLimitRequest
+	}
+
+	budgets = map[routes.RouteClass]uint64{
+		routes.RouteClassPage:   config.GlobalConfig.RequestLimit,
+		routes.RouteClassMulti:  config.GlobalConfig.RequestLimitMulti,
+		routes.RouteClassSearch: config.GlobalConfig.RequestLimitSearch,
+		routes.RouteClassProxy:  config.GlobalConfig.RequestLimitProxy,
+		routes.RouteClassAction: config.GlobalConfig.RequestLimitAction,
+	}
+
+	limiters = make(map[routes.RouteClass]limiter.Store, len(budgets))
+	for class, tokens := range budgets {
+		store, err := NewIPRateLimiter(tokens, limiterInterval)
 		if err != nil {
 			log.Panic(err)
 		}
+		limiters[class] = store
 	}
 	return rateLimitRequest
 }
 
+// takeTokens takes cost tokens from the store for key.
+// It returns whether the request is allowed and when the bucket resets.
+//
+// A request is either charged its full cost or nothing: when the bucket runs out
+// partway, the tokens already taken are given back.
+func takeTokens(ctx context.Context, store limiter.Store, key string, cost uint64) (bool, time.Time, error) {
+	var reset uint64
+	for taken := uint64(0); taken < cost; taken++ {
+		_, _, r, ok, err := store.Take(ctx, key)
+		if err != nil || !ok {
+			if taken > 0 {
+				if burstErr := store.Burst(ctx, key, taken); err == nil {
+					err = burstErr
+				}
+			}
+			if err != nil {
+				return false, time.Time{}, err
+			}
+			return false, time.Unix(0, int64(r)), nil
+		}
+		reset = r
+	}
+	return true, time.Unix(0, int64(reset)), nil
+}
+
+// retryAfter returns how long a client should wait for the bucket to reset, at least one second.
+func retryAfter(reset time.Time) time.Duration {
+	return max(time.Until(reset).Round(time.Second), time.Second)
+}
+
 // RateLimitRequest is a middleware that applies rate limiting to incoming HTTP requests.
+// Each request takes tokens from the budget of its route class, as determined by ClassifyRequest and RequestCost.
 // It exempts certain requests (as defined by CanRequestSkipLimiter) from rate limiting.
 func rateLimitRequest(h http.Handler) http.Handler {
-	if limiter == nil {
+	if limiters == nil {
 		return h
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -70,6 +168,40 @@ func rateLimitRequest(h http.Handler) http.Handler {
 			h.ServeHTTP(w, r)
 			return
 		}
-		limiter.Handle(h).ServeHTTP(w, r)
+
+		key, err := limiterKey(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		class := ClassifyRequest(r)
+		cost := min(RequestCost(r, class), budgets[class])
+		ok, reset, err := takeTokens(r.Context(), limiters[class], key, cost)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !ok {
+			routes.RateLimitedPage(w, r, class, retryAfter(reset))
+			return
+		}
+
+		// further tokens can be charged by the handler, up to what is left of the budget of the class
+		ctx := request_context.Get(r)
+		ctx.ChargeLimit = budgets[class] - cost
+		ctx.ChargeTokens = func(cost uint64) error {
+			ok, reset, err := takeTokens(r.Context(), limiters[class], key, cost)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return &routes.RateLimitedError{Class: class, RetryAfter: retryAfter(reset)}
+			}
+			return nil
+		}
+
+		h.ServeHTTP(w, r)
 	})
 }
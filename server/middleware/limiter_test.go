@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
+	"codeberg.org/vnpower/pixivfe/v2/server/routes"
+)
+
+func TestClassifyRequest(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		method, path string
+		want         routes.RouteClass
+	}{
+		{"GET", "/artworks/123", routes.RouteClassPage},
+		{"GET", "/artworks/123/ugoira.gif", routes.RouteClassProxy},
+		{"GET", "/artworks/123/ugoira.zip", routes.RouteClassProxy},
+		{"GET", "/artworks/123/comments", routes.RouteClassPage},
+		{"GET", "/artworks/123/comments/456/replies", routes.RouteClassPage},
+		{"GET", "/proxy/i.pximg.net/img-original/img/1.png", routes.RouteClassProxy},
+		{"GET", "/artworks-multi/1,2", routes.RouteClassMulti},
+		{"GET", "/tags/cat", routes.RouteClassSearch},
+		{"POST", "/self/like/123", routes.RouteClassAction},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := ClassifyRequest(r); got != tt.want {
+			t.Errorf("ClassifyRequest(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTakeTokens(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewIPRateLimiter(5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := takeTokens(ctx, store, "a", 3); !ok || err != nil {
+		t.Fatalf("Expected the first request to be allowed, got %v, %v", ok, err)
+	}
+	if ok, _, err := takeTokens(ctx, store, "a", 3); ok || err != nil {
+		t.Fatalf("Expected the second request to be limited, got %v, %v", ok, err)
+	}
+	// the limited request must not have drained the two tokens left
+	if _, remaining, _ := store.Get(ctx, "a"); remaining != 2 {
+		t.Errorf("Expected 2 remaining tokens, got %d", remaining)
+	}
+	if ok, _, err := takeTokens(ctx, store, "a", 2); !ok || err != nil {
+		t.Errorf("Expected a request within the remaining tokens to be allowed, got %v, %v", ok, err)
+	}
+}
+
+func TestChargeRequest(t *testing.T) {
+	config.GlobalConfig.RequestLimit = 5
+	config.GlobalConfig.RequestLimitMulti = 5
+	config.GlobalConfig.RequestLimitSearch = 5
+	config.GlobalConfig.RequestLimitProxy = 5
+	config.GlobalConfig.RequestLimitAction = 5
+	defer func() { config.GlobalConfig.RequestLimit = 0 }()
+
+	var charges []error
+	cost := 0
+	handler := InitializeRateLimiter()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		charges = append(charges, routes.ChargeRequest(r, cost))
+	}))
+	serve := func(ip string, c int) {
+		cost = c
+		r := httptest.NewRequest("GET", "/artworks/1/download.zip", nil)
+		r.RemoteAddr = ip + ":1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(request_context.ProvideWith(r.Context())))
+	}
+
+	serve("192.0.2.1", 3) // 1 + 3 tokens
+	serve("192.0.2.1", 3) // 1 token, then 3 more than are left
+	var rateLimited *routes.RateLimitedError
+	if len(charges) != 2 || charges[0] != nil || !errors.As(charges[1], &rateLimited) {
+		t.Fatalf("Expected the second charge to be rate limited, got %v", charges)
+	}
+
+	// 1 + 5 tokens can never fit in a budget of 5, so the request is refused without taking any
+	serve("192.0.2.2", 5)
+	serve("192.0.2.2", 3) // 1 + 3 of the 4 tokens left
+	var tooLarge *routes.RequestTooLargeError
+	if !errors.As(charges[2], &tooLarge) || tooLarge.Limit != 4 || charges[3] != nil {
+		t.Errorf("Expected a charge over the budget to be refused as too large, got %v", charges[2:])
+	}
+}
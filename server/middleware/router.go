@@ -61,7 +61,10 @@ func DefineRoutes() *mux.Router {
 	// These routes maintain cache headers set by upstream servers
 	handleStripPrefix(router, "/proxy/i.pximg.net/", CatchError(routes.IPximgProxy)).Methods("GET")
 	handleStripPrefix(router, "/proxy/s.pximg.net/", CatchError(routes.SPximgProxy)).Methods("GET")
-	handleStripPrefix(router, "/proxy/ugoira.com/", CatchError(routes.UgoiraProxy)).Methods("GET")
+	handleStripPrefix(router, "/proxy/smart/", CatchError(routes.SmartPximgProxy)).Methods("GET")
+
+	// Proof-of-work challenge solutions are submitted here
+	router.HandleFunc(challengePath, VerifyChallenge).Methods("POST")
 
 	// Main application routes
 	router.HandleFunc("/", CatchError(routes.IndexPage)).Methods("GET")
@@ -69,6 +72,7 @@ func DefineRoutes() *mux.Router {
 	router.HandleFunc("/newest", CatchError(routes.NewestPage)).Methods("GET")
 	router.HandleFunc("/discovery", CatchError(routes.DiscoveryPage)).Methods("GET")
 	router.HandleFunc("/discovery/novel", CatchError(routes.NovelDiscoveryPage)).Methods("GET")
+	router.HandleFunc("/discovery/users", CatchError(routes.UserDiscoveryPage)).Methods("GET")
 
 	// Ranking related routes
 	router.HandleFunc("/ranking", CatchError(routes.RankingPage)).Methods("GET")
@@ -79,10 +83,17 @@ func DefineRoutes() *mux.Router {
 	router.HandleFunc("/users/{id}.atom.xml", CatchError(routes.UserAtomFeed)).Methods("GET")
 	router.HandleFunc("/users/{id}/{category}.atom.xml", CatchError(routes.UserAtomFeed)).Methods("GET")
 	router.HandleFunc("/users/{id}", CatchError(routes.UserPage)).Methods("GET")
+	router.HandleFunc("/users/{id}/following", CatchError(routes.UserFollowingPage)).Methods("GET")
+	router.HandleFunc("/users/{id}/followers", CatchError(routes.UserFollowersPage)).Methods("GET")
 	router.HandleFunc("/users/{id}/{category}", CatchError(routes.UserPage)).Methods("GET")
 
 	// Artwork related routes
 	router.HandleFunc("/artworks/{id}", CatchError(routes.ArtworkPage)).Methods("GET")
+	router.HandleFunc("/artworks/{id}/ugoira.{format:gif|png}", CatchError(routes.ArtworkUgoira)).Methods("GET")
+	router.HandleFunc("/artworks/{id}/ugoira.zip", CatchStreamError(routes.ArtworkUgoiraArchive)).Methods("GET")
+	router.HandleFunc("/artworks/{id}/download.zip", CatchStreamError(routes.ArtworkDownload)).Methods("GET")
+	router.HandleFunc("/artworks/{id}/comments", CatchError(routes.ArtworkCommentsPage)).Methods("GET")
+	router.HandleFunc("/artworks/{id}/comments/{comment}/replies", CatchError(routes.ArtworkCommentRepliesPage)).Methods("GET")
 	router.HandleFunc("/artworks-multi/{ids}", CatchError(routes.ArtworkMultiPage)).Methods("GET")
 	// Legacy illust URL redirect
 	router.HandleFunc("/member_illust.php", func(w http.ResponseWriter, r *http.Request) {
@@ -91,12 +102,17 @@ func DefineRoutes() *mux.Router {
 
 	// Manga related routes
 	router.HandleFunc("/user/{id}/series/{sid}", CatchError(routes.MangaSeriesPage)).Methods("GET")
+	router.HandleFunc("/user/{id}/series/{sid}/download.cbz", CatchStreamError(routes.MangaSeriesDownload)).Methods("GET")
 
 	// Novel related routes
 	router.HandleFunc("/novel/show.php", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/novel/"+routes.GetQueryParam(r, "id"), http.StatusPermanentRedirect)
 	}).Methods("GET")
+	router.HandleFunc("/novel/{id}.epub", CatchStreamError(routes.NovelEpub)).Methods("GET")
+	router.HandleFunc("/novel/series/{id}.epub", CatchStreamError(routes.NovelSeriesEpub)).Methods("GET")
 	router.HandleFunc("/novel/{id}", CatchError(routes.NovelPage)).Methods("GET")
+	router.HandleFunc("/novel/{id}/comments", CatchError(routes.NovelCommentsPage)).Methods("GET")
+	router.HandleFunc("/novel/{id}/comments/{comment}/replies", CatchError(routes.NovelCommentRepliesPage)).Methods("GET")
 	router.HandleFunc("/novel/series/{id}", CatchError(routes.NovelSeriesPage)).Methods("GET")
 
 	// Pixivision related routes
@@ -107,17 +123,31 @@ func DefineRoutes() *mux.Router {
 
 	// Settings related routes
 	router.HandleFunc("/settings", CatchError(routes.SettingsPage)).Methods("GET")
+	router.HandleFunc("/settings/proxies.json", CatchError(routes.ProxyStatsData)).Methods("GET")
 	router.HandleFunc("/settings/{type}", CatchError(routes.SettingsPost)).Methods("POST")
 
 	// User action routes (login, bookmarks, likes, etc.)
 	router.HandleFunc("/self", CatchError(routes.LoginUserPage)).Methods("GET")
 	router.HandleFunc("/self/followingWorks", CatchError(routes.FollowingWorksPage)).Methods("GET")
+	router.HandleFunc("/self/following", CatchError(routes.SelfFollowingPage)).Methods("GET")
 	router.HandleFunc("/self/bookmarks", CatchError(routes.LoginBookmarkPage)).Methods("GET")
+	router.HandleFunc("/self/bookmarks", CatchError(routes.BookmarksBulkRoute)).Methods("POST")
+	router.HandleFunc("/self/bookmarkTags", CatchError(routes.BookmarkTagsPage)).Methods("GET")
 	router.HandleFunc("/self/addBookmark/{id}", CatchError(routes.AddBookmarkRoute)).Methods("POST")
 	router.HandleFunc("/self/deleteBookmark/{id}", CatchError(routes.DeleteBookmarkRoute)).Methods("POST")
 	router.HandleFunc("/self/like/{id}", CatchError(routes.LikeRoute)).Methods("POST")
+	router.HandleFunc("/self/addNovelBookmark/{id}", CatchError(routes.AddNovelBookmarkRoute)).Methods("POST")
+	router.HandleFunc("/self/deleteNovelBookmark/{id}", CatchError(routes.DeleteNovelBookmarkRoute)).Methods("POST")
+	router.HandleFunc("/self/likeNovel/{id}", CatchError(routes.NovelLikeRoute)).Methods("POST")
+	router.HandleFunc("/self/watchSeries/{type}/{id}", CatchError(routes.WatchSeriesRoute)).Methods("POST")
+	router.HandleFunc("/self/unwatchSeries/{type}/{id}", CatchError(routes.UnwatchSeriesRoute)).Methods("POST")
+	router.HandleFunc("/self/novelPoll/{id}", CatchError(routes.NovelPollVoteRoute)).Methods("POST")
+	router.HandleFunc("/self/novelMarker/{id}", CatchError(routes.NovelMarkerRoute)).Methods("POST")
+	router.HandleFunc("/self/comment/{type}/{id}", CatchError(routes.PostCommentRoute)).Methods("POST")
+	router.HandleFunc("/self/deleteComment/{type}/{id}", CatchError(routes.DeleteCommentRoute)).Methods("POST")
 	router.HandleFunc("/self/followUser/{id}", CatchError(routes.FollowUserRoute)).Methods("POST")
 	router.HandleFunc("/self/unfollowUser/{id}", CatchError(routes.UnfollowUserRoute)).Methods("POST")
+	router.HandleFunc("/self/followRestrict/{id}", CatchError(routes.FollowRestrictRoute)).Methods("POST")
 
 	// oEmbed endpoint for embedding Pixiv content
 	router.HandleFunc("/oembed", CatchError(routes.Oembed)).Methods("GET")
@@ -3,75 +3,297 @@ package proxy_checker
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/utils"
 )
 
 const (
 	testImagePath = "/img-original/img/2024/01/21/20/50/51/115365120_p0.jpg"
+
+	// historySize is how many checks are kept per proxy
+	historySize = 48
+
+	// failureThreshold is how many failures a proxy must be reported for within failureWindow
+	// before it is no longer offered until the next check
+	failureThreshold = 3
+	failureWindow    = 5 * time.Minute
 )
 
+// probeImagePaths are requested from every proxy during a check.
+// They cover the kinds of images PixivFE loads: originals, master images and thumbnails.
+var probeImagePaths = []string{
+	testImagePath,
+	"/img-master/img/2024/01/21/20/50/51/115365120_p0_master1200.jpg",
+	"/c/250x250_80_a2/img-master/img/2024/01/21/20/50/51/115365120_p0_square1200.jpg",
+}
+
+// Check is the result of checking a proxy once.
+type Check struct {
+	Time       time.Time
+	OK         bool
+	Latency    time.Duration // time to the response headers, averaged over the probe images
+	Throughput float64       // in bytes per second, over all probe images
+}
+
+// ProxyStats summarizes the check history of a proxy.
+type ProxyStats struct {
+	URL                  string
+	Working              bool
+	Uptime               float64 // percentage of successful checks
+	MedianLatencyMs      float64
+	MedianThroughputKBps float64
+	Checks               int
+	LastChecked          time.Time
+	ReportedFailures     int // images the proxy failed to serve since the last check. Not part of Uptime
+}
+
 var (
 	workingProxies      []string
 	workingProxiesMutex sync.RWMutex
+
+	history      = make(map[string][]Check)
+	historyMutex sync.RWMutex
+
+	// reportedFailures holds the failures reported by ReportFailure since the last check, by proxy URL without trailing slash.
+	// They are kept apart from history so that the uptime only reflects the periodic checks.
+	reportedFailures = make(map[string]*failureReports) // guarded by historyMutex
 )
 
+// failureReports are the failures reported for a proxy since its last check.
+type failureReports struct {
+	count    int         // every failure since the last check
+	recent   []time.Time // the times of the last failureThreshold failures
+	disabled bool        // whether failureThreshold failures happened within failureWindow
+}
+
+// ProxyList returns the proxies that are checked and offered on /settings.
+func ProxyList() []string {
+	if len(config.GlobalConfig.ProxyList) > 0 {
+		return config.GlobalConfig.ProxyList
+	}
+	return config.BuiltinProxyList
+}
+
+// IsConfigured reports whether proxyURL is one of the proxies configured for this instance,
+// as opposed to one entered by a user.
+func IsConfigured(proxyURL string) bool {
+	proxyURL = strings.TrimRight(proxyURL, "/")
+	if proxyURL == strings.TrimRight(config.GlobalConfig.ProxyServer.String(), "/") {
+		return true
+	}
+	return slices.ContainsFunc(ProxyList(), func(p string) bool {
+		return strings.TrimRight(p, "/") == proxyURL
+	})
+}
+
 func CheckProxies(ctx context.Context) {
 	logln("Starting proxy check...")
 	var wg sync.WaitGroup
-	var mutex sync.Mutex
-	var newWorkingProxies []string
 
-	logf("Total proxies to check: %d", len(config.BuiltinProxyList))
+	proxies := ProxyList()
+	logf("Total proxies to check: %d", len(proxies))
 
-	for _, proxy := range config.BuiltinProxyList {
+	for _, proxy := range proxies {
 		wg.Add(1)
 		go func(proxyURL string) {
 			defer wg.Done()
-			isWorking, resp := testProxy(ctx, proxyURL)
-			status := ""
-			if resp != nil {
-				status = resp.Status
-			}
-			if isWorking {
-				mutex.Lock()
-				newWorkingProxies = append(newWorkingProxies, proxyURL)
-				mutex.Unlock()
-				logf("[OK]  %s %s", proxyURL, status)
+			check := probeProxy(ctx, proxyURL)
+			recordCheck(proxyURL, check)
+			if check.OK {
+				logf("[OK]  %s %v %.0f KB/s", proxyURL, check.Latency.Round(time.Millisecond), check.Throughput/1024)
 			} else {
-				logf("[ERR] %s %s", proxyURL, status)
+				logf("[ERR] %s", proxyURL)
 			}
 		}(proxy)
 	}
 
 	wg.Wait()
 
+	var newWorkingProxies []string
+	for _, stats := range RankedProxies() {
+		if stats.Working {
+			newWorkingProxies = append(newWorkingProxies, stats.URL)
+		}
+	}
 	updateWorkingProxies(newWorkingProxies)
 }
 
+// probeProxy requests every probe image from a proxy, measuring latency and throughput.
+// The check fails if any of the images fails to load.
+func probeProxy(ctx context.Context, proxyBaseURL string) Check {
+	check := Check{Time: time.Now(), OK: true}
+	var totalLatency, totalDuration time.Duration
+	var totalBytes int64
+
+	for _, path := range probeImagePaths {
+		isWorking, _, latency, size, duration := fetchImage(ctx, utils.HttpClient, proxyBaseURL, path)
+		if !isWorking {
+			check.OK = false
+			return check
+		}
+		totalLatency += latency
+		totalDuration += duration
+		totalBytes += size
+	}
+
+	check.Latency = totalLatency / time.Duration(len(probeImagePaths))
+	if totalDuration > 0 {
+		check.Throughput = float64(totalBytes) / totalDuration.Seconds()
+	}
+	return check
+}
+
+// testProxy checks whether a proxy can serve the original test image.
 func testProxy(ctx context.Context, proxyBaseURL string) (bool, *http.Response) {
-	fullURL := fmt.Sprintf("%s%s", strings.TrimRight(proxyBaseURL, "/"), testImagePath)
+	isWorking, resp, _, _, _ := fetchImage(ctx, utils.HttpClient, proxyBaseURL, testImagePath)
+	return isWorking, resp
+}
+
+// verifyClient is the client VerifyProxy uses. The proxies it checks are entered by users,
+// so it must not reach into the host's network.
+var verifyClient = utils.PublicHttpClient
+
+// VerifyProxy runs the same probe as the proxy checker against a single proxy entered by a user,
+// and describes why it failed.
+func VerifyProxy(ctx context.Context, proxyBaseURL string) error {
+	isWorking, resp, _, _, _ := fetchImage(ctx, verifyClient, proxyBaseURL, testImagePath)
+	if isWorking {
+		return nil
+	}
+	if resp == nil {
+		return i18n.Error("the proxy server could not be reached")
+	}
+	return i18n.Errorf("the proxy server responded with %s", resp.Status)
+}
+
+// fetchImage requests an image through a proxy and reads it completely.
+// It returns the time to the response headers, the number of bytes read and the total time taken.
+func fetchImage(ctx context.Context, client *http.Client, proxyBaseURL, path string) (bool, *http.Response, time.Duration, int64, time.Duration) {
+	fullURL := fmt.Sprintf("%s%s", strings.TrimRight(proxyBaseURL, "/"), path)
 	logf("Testing proxy %s with full URL: %s", proxyBaseURL, fullURL)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
 	if err != nil {
 		logf("Error creating request for proxy %s: %v", proxyBaseURL, err)
-		return false, nil
+		return false, nil, 0, 0, 0
 	}
 
-	resp, err := utils.HttpClient.Do(req)
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
 		logf("Error testing proxy %s: %v", proxyBaseURL, err)
-		return false, nil
+		return false, nil, 0, 0, 0
 	}
 	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	size, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		logf("Error reading from proxy %s: %v", proxyBaseURL, err)
+		return false, resp, latency, size, time.Since(start)
+	}
+
+	return resp.StatusCode == http.StatusOK, resp, latency, size, time.Since(start)
+}
+
+func recordCheck(proxyURL string, check Check) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	checks := append(history[proxyURL], check)
+	if len(checks) > historySize {
+		checks = checks[len(checks)-historySize:]
+	}
+	history[proxyURL] = checks
+	delete(reportedFailures, strings.TrimRight(proxyURL, "/"))
+}
+
+// summarize computes the statistics of a check history. The median latency and throughput only cover successful checks.
+func summarize(proxyURL string, checks []Check) ProxyStats {
+	stats := ProxyStats{URL: proxyURL, Checks: len(checks)}
+	if len(checks) == 0 {
+		return stats
+	}
+
+	var latencies, throughputs []float64
+	for _, check := range checks {
+		if check.OK {
+			latencies = append(latencies, float64(check.Latency)/float64(time.Millisecond))
+			throughputs = append(throughputs, check.Throughput/1024)
+		}
+	}
+
+	last := checks[len(checks)-1]
+	stats.Working = last.OK
+	stats.LastChecked = last.Time
+	stats.Uptime = float64(len(latencies)) * 100 / float64(len(checks))
+	stats.MedianLatencyMs = median(latencies)
+	stats.MedianThroughputKBps = median(throughputs)
+	return stats
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	values = slices.Clone(values)
+	slices.Sort(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
 
-	return resp.StatusCode == http.StatusOK, resp
+// RankedProxies returns the statistics of every proxy, best first:
+// working proxies before broken ones, then by uptime, then by median latency.
+// Proxies that haven't been checked yet come last.
+func RankedProxies() []ProxyStats {
+	historyMutex.RLock()
+	var ranked []ProxyStats
+	for _, proxyURL := range ProxyList() {
+		stats := summarize(proxyURL, history[proxyURL])
+		if failures := reportedFailures[strings.TrimRight(proxyURL, "/")]; failures != nil {
+			stats.ReportedFailures = failures.count
+			stats.Working = stats.Working && !failures.disabled
+		}
+		ranked = append(ranked, stats)
+	}
+	historyMutex.RUnlock()
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Working != b.Working {
+			return a.Working
+		}
+		if (a.Checks == 0) != (b.Checks == 0) {
+			return b.Checks == 0
+		}
+		if a.Uptime != b.Uptime {
+			return a.Uptime > b.Uptime
+		}
+		return a.MedianLatencyMs < b.MedianLatencyMs
+	})
+	return ranked
+}
+
+// SuggestedProxy returns the best working proxy, or an empty string if none is known to work.
+func SuggestedProxy() string {
+	working := GetWorkingProxies()
+	if len(working) == 0 {
+		return ""
+	}
+	return working[0]
 }
 
 func updateWorkingProxies(newProxies []string) {
@@ -82,6 +304,7 @@ func updateWorkingProxies(newProxies []string) {
 	logf("Updated working proxies. Count: %d", len(workingProxies))
 }
 
+// GetWorkingProxies returns the proxies that passed the last check, best first.
 func GetWorkingProxies() []string {
 	workingProxiesMutex.RLock()
 	defer workingProxiesMutex.RUnlock()
@@ -89,6 +312,51 @@ func GetWorkingProxies() []string {
 	return append([]string{}, workingProxies...)
 }
 
+// ReportFailure records that a proxy failed to serve an image.
+// The failure is counted in ProxyStats.ReportedFailures, apart from the checks.
+//
+// A single failure can be the client's doing, so a proxy is only marked as not working until the next check
+// once it failed failureThreshold times within failureWindow.
+//
+// Only configured proxies are counted, so that reportedFailures stays as small as the proxy list.
+func ReportFailure(proxyURL string) {
+	if !IsConfigured(proxyURL) {
+		return
+	}
+	proxyURL = strings.TrimRight(proxyURL, "/")
+	now := time.Now()
+
+	historyMutex.Lock()
+	failures := reportedFailures[proxyURL]
+	if failures == nil {
+		failures = &failureReports{}
+		reportedFailures[proxyURL] = failures
+	}
+	failures.count++
+	failures.recent = append(failures.recent, now)
+	if len(failures.recent) > failureThreshold {
+		failures.recent = failures.recent[1:]
+	}
+	disable := !failures.disabled && len(failures.recent) == failureThreshold && now.Sub(failures.recent[0]) <= failureWindow
+	failures.disabled = failures.disabled || disable
+	historyMutex.Unlock()
+
+	if !disable {
+		return
+	}
+
+	workingProxiesMutex.Lock()
+	defer workingProxiesMutex.Unlock()
+
+	count := len(workingProxies)
+	workingProxies = slices.DeleteFunc(workingProxies, func(p string) bool {
+		return strings.TrimRight(p, "/") == proxyURL
+	})
+	if len(workingProxies) != count {
+		logf("Proxy %s reported as not working. Count: %d", proxyURL, len(workingProxies))
+	}
+}
+
 // Helper functions for logging
 func logf(format string, v ...any) {
 	log.Printf(format, v...)
@@ -4,8 +4,13 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"codeberg.org/vnpower/pixivfe/v2/config"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
 )
 
 func TestUpdateAndGetWorkingProxies(t *testing.T) {
@@ -73,3 +78,120 @@ func TestTestProxy(t *testing.T) {
 		t.Errorf("Expected error status response")
 	}
 }
+
+func TestReportFailure(t *testing.T) {
+	config.GlobalConfig.ProxyList = []string{"http://proxy1.invalid", "http://proxy2.invalid/"}
+	defer func() { config.GlobalConfig.ProxyList = nil }()
+	updateWorkingProxies([]string{"http://proxy1.invalid", "http://proxy2.invalid/"})
+	recordCheck("http://proxy2.invalid/", Check{Time: time.Now(), OK: true})
+
+	ReportFailure("http://proxy2.invalid")
+	if result := GetWorkingProxies(); len(result) != 2 {
+		t.Errorf("Expected a single failure to keep both proxies, got %v", result)
+	}
+
+	for range failureThreshold - 1 {
+		ReportFailure("http://proxy2.invalid")
+	}
+	result := GetWorkingProxies()
+	if len(result) != 1 || result[0] != "http://proxy1.invalid" {
+		t.Errorf("Expected only proxy1 to remain, got %v", result)
+	}
+
+	historyMutex.RLock()
+	stats := summarize("http://proxy2.invalid/", history["http://proxy2.invalid/"])
+	failures := reportedFailures["http://proxy2.invalid"]
+	historyMutex.RUnlock()
+	if stats.Checks != 1 || stats.Uptime != 100 {
+		t.Errorf("Expected the reported failures to stay out of the checks, got %+v", stats)
+	}
+	if failures == nil || failures.count != failureThreshold || !failures.disabled {
+		t.Errorf("Expected %d reported failures disabling the proxy, got %+v", failureThreshold, failures)
+	}
+
+	// Proxies entered by users are not counted
+	ReportFailure("http://user.invalid")
+	historyMutex.RLock()
+	_, counted := reportedFailures["http://user.invalid"]
+	historyMutex.RUnlock()
+	if counted {
+		t.Error("Expected a proxy that isn't configured to be ignored")
+	}
+
+	// The next check resets the reported failures
+	recordCheck("http://proxy2.invalid/", Check{Time: time.Now(), OK: true})
+	historyMutex.RLock()
+	failures = reportedFailures["http://proxy2.invalid"]
+	historyMutex.RUnlock()
+	if failures != nil {
+		t.Errorf("Expected the reported failures to be reset, got %+v", failures)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	checks := []Check{
+		{Time: now, OK: true, Latency: 100 * time.Millisecond, Throughput: 1024},
+		{Time: now, OK: false},
+		{Time: now, OK: true, Latency: 300 * time.Millisecond, Throughput: 3072},
+		{Time: now, OK: true, Latency: 200 * time.Millisecond, Throughput: 2048},
+	}
+
+	stats := summarize("http://proxy.invalid", checks)
+	if !stats.Working || stats.Checks != 4 || stats.Uptime != 75 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.MedianLatencyMs != 200 || stats.MedianThroughputKBps != 2 {
+		t.Errorf("Expected median of successful checks, got %+v", stats)
+	}
+}
+
+func TestProbeProxy(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1024))
+	}))
+	defer server.Close()
+
+	check := probeProxy(context.Background(), server.URL)
+	if !check.OK || check.Throughput <= 0 {
+		t.Errorf("Expected proxy to be working, got %+v", check)
+	}
+
+	// a proxy that can't serve thumbnails is broken
+	partialServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/c/") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer partialServer.Close()
+
+	if check := probeProxy(context.Background(), partialServer.URL); check.OK {
+		t.Errorf("Expected proxy to be not working")
+	}
+}
+
+func TestVerifyProxy(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	// the test server is on a loopback address, which users must not be able to reach
+	if err := VerifyProxy(context.Background(), server.URL); err == nil || strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected loopback proxy to be refused, got %v", err)
+	}
+
+	verifyClient = utils.HttpClient
+	defer func() { verifyClient = utils.PublicHttpClient }()
+
+	err := VerifyProxy(context.Background(), server.URL)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected error mentioning the status, got %v", err)
+	}
+	if err := VerifyProxy(context.Background(), "http://nonexistentproxy.invalid"); err == nil {
+		t.Errorf("Expected error for unreachable proxy")
+	}
+}
@@ -20,6 +20,10 @@ type RequestContext struct {
 	CaughtError error
 	// for Render[T]
 	RenderStatusCode int
+	// for handlers whose cost is only known once they run. set by the rate limiter, nil if it is disabled
+	ChargeTokens func(cost uint64) error
+	// how many tokens can still be charged with ChargeTokens. a request that needs more can never go through
+	ChargeLimit uint64
 }
 
 func Make() RequestContext {
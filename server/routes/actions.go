@@ -5,15 +5,77 @@ import (
 	"fmt"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
 	"codeberg.org/vnpower/pixivfe/v2/server/utils"
+	"github.com/goccy/go-json"
 )
 
 // NOTE: is the csrf protection by the upstream Pixiv API itself good enough, or do we need to implement our own?
 
+// Pixiv's limits on bookmark options
+const (
+	maxBookmarkTags          = 10
+	maxBookmarkCommentLength = 140
+)
+
+// Pixiv's limit on comment length
+const maxCommentLength = 140
+
+// commentRPC holds where comments on artworks and novels are posted and deleted,
+// and the name of the work's ID when posting.
+var commentRPC = map[string]struct{ post, delete, idKey string }{
+	core.CommentTargetIllust: {"https://www.pixiv.net/rpc/post_comment.php", "https://www.pixiv.net/rpc_delete_comment.php", "illust_id"},
+	core.CommentTargetNovel:  {"https://www.pixiv.net/novel/rpc/post_comment.php", "https://www.pixiv.net/novel/rpc_delete_comment.php", "novel_id"},
+}
+
+// bookmarkPayload builds the body of a bookmarks/add request from the bookmark form, if any.
+//
+// "private" makes the bookmark private, "tag" can be given several times and "tags" holds space-separated tags.
+// Without a form, the bookmark is public, untagged and without a comment.
+func bookmarkPayload(r *http.Request, idKey, id string) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+	return bookmarkFormPayload(r.Form, idKey, id)
+}
+
+// bookmarkFormPayload builds the body of a bookmarks/add request from the values of the bookmark form.
+func bookmarkFormPayload(form url.Values, idKey, id string) (string, error) {
+	restrict := 0
+	if bookmarkFormPrivate(form) {
+		restrict = 1
+	}
+
+	tags, err := bookmarkFormTags(form)
+	if err != nil {
+		return "", err
+	}
+
+	comment := strings.TrimSpace(form.Get("comment"))
+	if utf8.RuneCountInString(comment) > maxBookmarkCommentLength {
+		return "", i18n.Errorf("A bookmark comment can be at most %d characters long.", maxBookmarkCommentLength)
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		idKey:      id,
+		"restrict": restrict,
+		"comment":  comment,
+		"tags":     tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
 func AddBookmarkRoute(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != http.MethodPost {
 		return i18n.Error("Method not allowed")
@@ -31,16 +93,31 @@ func AddBookmarkRoute(w http.ResponseWriter, r *http.Request) error {
 		return i18n.Error("No ID provided.")
 	}
 
+	contentType := "application/json; charset=utf-8"
+
+	// an existing bookmark (Illust.BookmarkID) is edited in place
+	if bookmarkID := r.PostFormValue("bookmark_id"); bookmarkID != "" {
+		requests, err := bookmarkEditRequests(r.PostForm, id, bookmarkID)
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if _, err := core.API_POST(r.Context(), request.URL, request.Payload, token, csrf, contentType); err != nil {
+				return err
+			}
+		}
+
+		utils.RedirectToWhenceYouCame(w, r)
+		return nil
+	}
+
 	URL := "https://www.pixiv.net/ajax/illusts/bookmarks/add"
-	payload := fmt.Sprintf(`{
-        "illust_id": "%s",
-        "restrict": 0,
-        "comment": "",
-        "tags": []
-    }`, id)
+	payload, err := bookmarkPayload(r, "illust_id", id)
+	if err != nil {
+		return err
+	}
 
-	contentType := "application/json; charset=utf-8"
-	_, err := core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
+	_, err = core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
 	if err != nil {
 		return err
 	}
@@ -79,6 +156,166 @@ func DeleteBookmarkRoute(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// bookmarkFormPrivate reports whether the bookmark form asks for a private bookmark.
+func bookmarkFormPrivate(form url.Values) bool {
+	private := form.Get("private")
+	return private == "true" || private == "on"
+}
+
+// bookmarkFormTags returns the tags chosen in the bookmark form, once each.
+func bookmarkFormTags(form url.Values) ([]string, error) {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range append(form["tag"], strings.Fields(form.Get("tags"))...) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tags) == maxBookmarkTags {
+			return nil, i18n.Errorf("A bookmark can have at most %d tags.", maxBookmarkTags)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// apiRequest is a POST request to the Pixiv API, with a JSON body.
+type apiRequest struct {
+	URL     string
+	Payload string
+}
+
+// bookmarkEditRequests returns the requests that apply the bookmark form to the existing bookmark of an artwork.
+//
+// With "replace" set, the bookmark is added again with the visibility, tags and comment of the form.
+// Pixiv keeps a single bookmark per work, so this replaces its tags and comment in place.
+//
+// Otherwise the bookmark keeps its comment: its visibility is set, the chosen tags are added,
+// and the space-separated "remove_tags" are removed from it.
+func bookmarkEditRequests(form url.Values, illustID, bookmarkID string) ([]apiRequest, error) {
+	if _, err := strconv.Atoi(illustID); err != nil {
+		return nil, i18n.Errorf("Invalid ID: %s", illustID)
+	}
+	if replace := form.Get("replace"); replace == "true" || replace == "on" {
+		payload, err := bookmarkFormPayload(form, "illust_id", illustID)
+		if err != nil {
+			return nil, err
+		}
+		return []apiRequest{{"https://www.pixiv.net/ajax/illusts/bookmarks/add", payload}}, nil
+	}
+
+	removeTags := strings.Fields(form.Get("remove_tags"))
+	tags, err := bookmarkFormTags(form)
+	if err != nil {
+		return nil, err
+	}
+	tags = slices.DeleteFunc(tags, func(tag string) bool {
+		return slices.Contains(removeTags, tag)
+	})
+
+	action := "public"
+	if bookmarkFormPrivate(form) {
+		action = "private"
+	}
+	actions := []url.Values{{"bookmark_id": {bookmarkID}, "action": {action}}}
+	if len(tags) > 0 {
+		actions = append(actions, url.Values{"bookmark_id": {bookmarkID}, "action": {"addTags"}, "tags": {strings.Join(tags, " ")}})
+	}
+	if len(removeTags) > 0 {
+		actions = append(actions, url.Values{"bookmark_id": {bookmarkID}, "action": {"removeTags"}, "tags": {strings.Join(removeTags, " ")}})
+	}
+
+	requests := make([]apiRequest, 0, len(actions))
+	for _, action := range actions {
+		URL, payload, err := bookmarksBulkRequest(action)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, apiRequest{URL, payload})
+	}
+	return requests, nil
+}
+
+// bookmarksBulkRequest returns the URL and the body of the request for a bulk action on bookmarks.
+//
+// The form holds the action, the IDs of the selected bookmarks ("bookmark_id", repeated)
+// and, when adding or removing tags, space-separated "tags".
+func bookmarksBulkRequest(form url.Values) (string, string, error) {
+	ids := form["bookmark_id"]
+	if len(ids) == 0 {
+		return "", "", i18n.Error("No bookmarks selected.")
+	}
+	for _, id := range ids {
+		if _, err := strconv.Atoi(id); err != nil {
+			return "", "", i18n.Errorf("Invalid bookmark ID: %s", id)
+		}
+	}
+	tags := strings.Fields(form.Get("tags"))
+
+	var URL string
+	body := map[string]any{"bookmarkIds": ids}
+
+	switch action := form.Get("action"); action {
+	case "remove":
+		URL = "https://www.pixiv.net/ajax/illusts/bookmarks/remove"
+	case "public", "private":
+		URL = "https://www.pixiv.net/ajax/illusts/bookmarks/edit_restrict"
+		body["bookmarkRestrict"] = action
+	case "addTags", "removeTags":
+		if len(tags) == 0 {
+			return "", "", i18n.Error("No tags provided.")
+		}
+		if action == "addTags" {
+			URL = "https://www.pixiv.net/ajax/illusts/bookmarks/add_tags"
+			body["tags"] = tags
+		} else {
+			URL = "https://www.pixiv.net/ajax/illusts/bookmarks/remove_tags"
+			body["removeTags"] = tags
+		}
+	default:
+		return "", "", i18n.Errorf("Invalid action: %s", action)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", "", err
+	}
+	return URL, string(payload), nil
+}
+
+// BookmarksBulkRoute removes, moves between public and private, or tags several of the user's bookmarks at once.
+func BookmarksBulkRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	URL, payload, err := bookmarksBulkRequest(r.PostForm)
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
 func LikeRoute(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != http.MethodPost {
 		return i18n.Error("Method not allowed")
@@ -109,6 +346,242 @@ func LikeRoute(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func AddNovelBookmarkRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if id == "" {
+		return i18n.Error("No ID provided.")
+	}
+
+	URL := "https://www.pixiv.net/ajax/novels/bookmarks/add"
+	payload, err := bookmarkPayload(r, "novel_id", id)
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+// DeleteNovelBookmarkRoute takes the bookmark ID (Novel.BookmarkID), not the novel ID.
+func DeleteNovelBookmarkRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if id == "" {
+		return i18n.Error("No ID provided.")
+	}
+
+	URL := "https://www.pixiv.net/ajax/novels/bookmarks/delete"
+	payload := fmt.Sprintf("del=1&book_id=%s", url.QueryEscape(id))
+
+	contentType := "application/x-www-form-urlencoded; charset=utf-8"
+	_, err := core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+func NovelLikeRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	URL := "https://www.pixiv.net/ajax/novels/like"
+	payload, err := json.Marshal(map[string]string{"novel_id": id})
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, string(payload), token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+// seriesWatchURL returns the URL to watch or unwatch a series. kind is "novel" or "manga", action is "watch" or "unwatch".
+func seriesWatchURL(kind, id, action string) (string, error) {
+	switch kind {
+	case "novel":
+		return fmt.Sprintf("https://www.pixiv.net/ajax/novel/series/%s/%s", id, action), nil
+	case "manga":
+		return fmt.Sprintf("https://www.pixiv.net/ajax/series/%s/%s", id, action), nil
+	default:
+		return "", i18n.Errorf("Invalid series type: %s", kind)
+	}
+}
+
+func WatchSeriesRoute(w http.ResponseWriter, r *http.Request) error {
+	return watchSeries(w, r, "watch")
+}
+
+func UnwatchSeriesRoute(w http.ResponseWriter, r *http.Request) error {
+	return watchSeries(w, r, "unwatch")
+}
+
+func watchSeries(w http.ResponseWriter, r *http.Request, action string) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Error("No series ID provided.")
+	}
+
+	URL, err := seriesWatchURL(GetPathVar(r, "type"), id, action)
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, "{}", token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+func NovelPollVoteRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	choice, err := strconv.Atoi(r.FormValue("choice"))
+	if err != nil || choice < 1 {
+		return i18n.Error("No choice selected.")
+	}
+
+	URL := fmt.Sprintf("https://www.pixiv.net/ajax/novel/%s/poll/answer", id)
+	payload := fmt.Sprintf(`{"choice_id": %d}`, choice)
+
+	contentType := "application/json; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, payload, token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+// NovelMarkerRoute saves the page the user is reading as their marker on Pixiv, or removes the marker if the page is 0.
+func NovelMarkerRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if id == "" {
+		return i18n.Error("No ID provided.")
+	}
+
+	page, err := strconv.Atoi(r.FormValue("page"))
+	if err != nil || page < 0 {
+		return i18n.Error("Invalid page.")
+	}
+
+	userId := strings.Split(token, "_")[0]
+
+	form := url.Values{}
+	form.Set("i_id", id)
+	form.Set("u_id", userId)
+	if page == 0 {
+		form.Set("mode", "delete")
+	} else {
+		form.Set("mode", "save")
+		form.Set("page", strconv.Itoa(page))
+	}
+
+	URL := "https://www.pixiv.net/novel/rpc_marker.php"
+
+	contentType := "application/x-www-form-urlencoded; charset=utf-8"
+	_, err = core.API_POST(r.Context(), URL, form.Encode(), token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	// go back to where the reader was
+	target := "/novel/" + id
+	if page != 0 {
+		target += fmt.Sprintf("#novel-page-%d", page)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+	return nil
+}
+
 /*
 NOTE: we're using the mobile API for FollowUserRoute and UnfollowUserRoute since it's an actual AJAX API
 			instead of some weird php thing for the usual desktop routes (/bookmark_add.php and /rpc_group_setting.php)
@@ -205,3 +678,160 @@ func UnfollowUserRoute(w http.ResponseWriter, r *http.Request) error {
 	utils.RedirectToWhenceYouCame(w, r)
 	return nil
 }
+
+// FollowRestrictRoute moves a followed user between the public and private following lists.
+// Following the user again with another restrict value wouldn't change it.
+func FollowRestrictRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	URL := "https://www.pixiv.net/rpc/index.php"
+	form := followRestrictForm(id, r.FormValue("private") == "true")
+
+	contentType := "application/x-www-form-urlencoded; charset=utf-8"
+	_, err := core.API_POST(r.Context(), URL, form.Encode(), token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+// followRestrictForm builds the body of the rpc request that changes the visibility of a follow.
+func followRestrictForm(id string, private bool) url.Values {
+	restrict := "0"
+	if private {
+		restrict = "1"
+	}
+
+	form := url.Values{}
+	form.Set("mode", "following_user_restrict_change")
+	form.Set("user_id", id)
+	form.Set("restrict", restrict)
+	return form
+}
+
+// commentForm builds the body of a post_comment.php request from the comment form.
+// "author" is the work's author and "parent" is the comment being replied to, if any.
+func commentForm(r *http.Request, idKey, id string) (url.Values, error) {
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if comment == "" {
+		return nil, i18n.Error("The comment is empty.")
+	}
+	if utf8.RuneCountInString(comment) > maxCommentLength {
+		return nil, i18n.Errorf("A comment can be at most %d characters long.", maxCommentLength)
+	}
+
+	author := r.FormValue("author")
+	if _, err := strconv.Atoi(author); err != nil {
+		return nil, i18n.Error("No author provided.")
+	}
+
+	form := url.Values{}
+	form.Set("type", "comment")
+	form.Set(idKey, id)
+	form.Set("author_user_id", author)
+	form.Set("comment", comment)
+
+	if parent := r.FormValue("parent"); parent != "" {
+		if _, err := strconv.Atoi(parent); err != nil {
+			return nil, i18n.Errorf("Invalid ID: %s", parent)
+		}
+		form.Set("parent_id", parent)
+	}
+	return form, nil
+}
+
+// PostCommentRoute posts a comment on an artwork or novel, or a reply to one of its comments.
+func PostCommentRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	rpc, ok := commentRPC[GetPathVar(r, "type")]
+	if !ok {
+		return i18n.Error("Invalid comment target.")
+	}
+
+	id := GetPathVar(r, "id")
+	if id == "" {
+		return i18n.Error("No ID provided.")
+	}
+
+	form, err := commentForm(r, rpc.idKey, id)
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/x-www-form-urlencoded; charset=utf-8"
+	_, err = core.API_POST(r.Context(), rpc.post, form.Encode(), token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
+
+// DeleteCommentRoute deletes one of the user's own comments on an artwork or novel.
+func DeleteCommentRoute(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return i18n.Error("Method not allowed")
+	}
+
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+
+	if token == "" || csrf == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	rpc, ok := commentRPC[GetPathVar(r, "type")]
+	if !ok {
+		return i18n.Error("Invalid comment target.")
+	}
+
+	id := GetPathVar(r, "id")
+	if id == "" {
+		return i18n.Error("No ID provided.")
+	}
+
+	comment := r.FormValue("comment")
+	if _, err := strconv.Atoi(comment); err != nil {
+		return i18n.Errorf("Invalid ID: %s", comment)
+	}
+
+	form := url.Values{}
+	form.Set("i_id", id)
+	form.Set("del_id", comment)
+
+	contentType := "application/x-www-form-urlencoded; charset=utf-8"
+	_, err := core.API_POST(r.Context(), rpc.delete, form.Encode(), token, csrf, contentType)
+	if err != nil {
+		return err
+	}
+
+	utils.RedirectToWhenceYouCame(w, r)
+	return nil
+}
@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestBookmarkPayload(t *testing.T) {
+	form := url.Values{
+		"private": {"true"},
+		"tag":     {"a", "b"},
+		"tags":    {" b  c "},
+		"comment": {" nice "},
+	}
+	r := httptest.NewRequest("POST", "/self/addBookmark/1", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	payload, err := bookmarkPayload(r, "illust_id", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		IllustID string   `json:"illust_id"`
+		Restrict int      `json:"restrict"`
+		Comment  string   `json:"comment"`
+		Tags     []string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(payload), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.IllustID != "1" || got.Restrict != 1 || got.Comment != "nice" || strings.Join(got.Tags, ",") != "a,b,c" {
+		t.Errorf("unexpected payload %s", payload)
+	}
+}
+
+func TestBookmarkPayloadDefaults(t *testing.T) {
+	r := httptest.NewRequest("POST", "/self/addNovelBookmark/1", nil)
+
+	payload, err := bookmarkPayload(r, "novel_id", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload, `"restrict":0`) || !strings.Contains(payload, `"tags":[]`) || !strings.Contains(payload, `"novel_id":"1"`) {
+		t.Errorf("unexpected payload %s", payload)
+	}
+}
+
+func TestBookmarkPayloadTooManyTags(t *testing.T) {
+	form := url.Values{"tags": {"1 2 3 4 5 6 7 8 9 10 11"}}
+	r := httptest.NewRequest("POST", "/self/addBookmark/1", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if _, err := bookmarkPayload(r, "illust_id", "1"); err == nil {
+		t.Error("expected an error for more than 10 tags")
+	}
+}
+
+func TestBookmarksBulkRequest(t *testing.T) {
+	tests := []struct {
+		form    url.Values
+		url     string
+		payload string
+	}{
+		{
+			url.Values{"action": {"remove"}, "bookmark_id": {"1", "2"}},
+			"https://www.pixiv.net/ajax/illusts/bookmarks/remove",
+			`{"bookmarkIds":["1","2"]}`,
+		},
+		{
+			url.Values{"action": {"private"}, "bookmark_id": {"1"}},
+			"https://www.pixiv.net/ajax/illusts/bookmarks/edit_restrict",
+			`{"bookmarkIds":["1"],"bookmarkRestrict":"private"}`,
+		},
+		{
+			url.Values{"action": {"addTags"}, "bookmark_id": {"1"}, "tags": {"a  b"}},
+			"https://www.pixiv.net/ajax/illusts/bookmarks/add_tags",
+			`{"bookmarkIds":["1"],"tags":["a","b"]}`,
+		},
+		{
+			url.Values{"action": {"removeTags"}, "bookmark_id": {"1"}, "tags": {"a"}},
+			"https://www.pixiv.net/ajax/illusts/bookmarks/remove_tags",
+			`{"bookmarkIds":["1"],"removeTags":["a"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		URL, payload, err := bookmarksBulkRequest(test.form)
+		if err != nil {
+			t.Errorf("%v: %v", test.form, err)
+			continue
+		}
+		if URL != test.url || payload != test.payload {
+			t.Errorf("%v: got %s %s, want %s %s", test.form, URL, payload, test.url, test.payload)
+		}
+	}
+
+	for _, form := range []url.Values{
+		{"action": {"remove"}},
+		{"action": {"remove"}, "bookmark_id": {"1 OR 1"}},
+		{"action": {"addTags"}, "bookmark_id": {"1"}},
+		{"action": {"explode"}, "bookmark_id": {"1"}},
+	} {
+		if _, _, err := bookmarksBulkRequest(form); err == nil {
+			t.Errorf("%v: expected an error", form)
+		}
+	}
+}
+
+func TestBookmarkEditRequests(t *testing.T) {
+	form := url.Values{"bookmark_id": {"7"}, "private": {"true"}, "tag": {"a", "c"}, "tags": {"b a"}, "remove_tags": {"c d"}, "comment": {"ignored"}}
+	requests, err := bookmarkEditRequests(form, "1", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []apiRequest{
+		{"https://www.pixiv.net/ajax/illusts/bookmarks/edit_restrict", `{"bookmarkIds":["7"],"bookmarkRestrict":"private"}`},
+		{"https://www.pixiv.net/ajax/illusts/bookmarks/add_tags", `{"bookmarkIds":["7"],"tags":["a","b"]}`},
+		{"https://www.pixiv.net/ajax/illusts/bookmarks/remove_tags", `{"bookmarkIds":["7"],"removeTags":["c","d"]}`},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got %v, want %v", requests, want)
+	}
+
+	// replacing sets the tags and comment of the form
+	form = url.Values{"bookmark_id": {"7"}, "replace": {"on"}, "tags": {"a"}, "comment": {" new "}}
+	requests, err = bookmarkEditRequests(form, "1", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []apiRequest{
+		{"https://www.pixiv.net/ajax/illusts/bookmarks/add", `{"comment":"new","illust_id":"1","restrict":0,"tags":["a"]}`},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got %v, want %v", requests, want)
+	}
+
+	// without tags, only the visibility is set
+	requests, err = bookmarkEditRequests(url.Values{}, "1", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].Payload != `{"bookmarkIds":["7"],"bookmarkRestrict":"public"}` {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
+
+func TestCommentForm(t *testing.T) {
+	newRequest := func(form url.Values) *http.Request {
+		r := httptest.NewRequest("POST", "/self/comment/illust/1", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	form, err := commentForm(newRequest(url.Values{"comment": {" nice "}, "author": {"2"}, "parent": {"3"}}), "illust_id", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "author_user_id=2&comment=nice&illust_id=1&parent_id=3&type=comment"
+	if form.Encode() != want {
+		t.Errorf("got %s, want %s", form.Encode(), want)
+	}
+
+	for _, invalid := range []url.Values{
+		{"comment": {"  "}, "author": {"2"}},
+		{"comment": {strings.Repeat("あ", maxCommentLength+1)}, "author": {"2"}},
+		{"comment": {"nice"}},
+		{"comment": {"nice"}, "author": {"2"}, "parent": {"x"}},
+	} {
+		if _, err := commentForm(newRequest(invalid), "illust_id", "1"); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestFollowRestrictForm(t *testing.T) {
+	if got := followRestrictForm("5", true).Encode(); got != "mode=following_user_restrict_change&restrict=1&user_id=5" {
+		t.Errorf("unexpected form %s", got)
+	}
+	if got := followRestrictForm("5", false).Get("restrict"); got != "0" {
+		t.Errorf("restrict = %s, want 0", got)
+	}
+}
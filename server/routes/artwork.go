@@ -7,6 +7,8 @@ import (
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
 )
 
 func ArtworkPage(w http.ResponseWriter, r *http.Request) error {
@@ -19,6 +21,11 @@ func ArtworkPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	filter := core.GetFilter(r)
+	var hiddenRelated, hiddenRecent int
+	illust.RelatedWorks, hiddenRelated = filter.Artworks(illust.RelatedWorks)
+	// the recent works are all by the artist whose work was opened
+	illust.RecentWorks, hiddenRecent = filter.WithoutMutedUsers().Artworks(illust.RecentWorks)
 
 	metaDescription := ""
 	for _, i := range illust.Tags {
@@ -37,9 +44,56 @@ func ArtworkPage(w http.ResponseWriter, r *http.Request) error {
 		MetaImage:       illust.Images[0].Original,
 		MetaAuthor:      illust.UserName,
 		MetaAuthorID:    illust.UserID,
+		Hidden:          hiddenRelated + hiddenRecent,
 	})
 }
 
+// ArtworkUgoira serves an ugoira as an animated GIF or APNG, assembled by PixivFE itself.
+func ArtworkUgoira(w http.ResponseWriter, r *http.Request) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	return image_proxy.ServeUgoira(w, r, id, GetPathVar(r, "format"))
+}
+
+// ArtworkUgoiraArchive serves the original frames of an ugoira and their delays as a ZIP.
+func ArtworkUgoiraArchive(w http.ResponseWriter, r *http.Request) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	return image_proxy.ServeUgoiraArchive(w, r, id)
+}
+
+// ArtworkDownload streams the original images of every page of an artwork as a ZIP, with a metadata.json.
+func ArtworkDownload(w http.ResponseWriter, r *http.Request) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	illust, err := core.GetArtworkByID(r, id, false)
+	if err != nil {
+		return err
+	}
+
+	// the image URLs already point to the user's image proxy, while the server fetches from i.pximg.net
+	originals := make([]string, 0, len(illust.Images))
+	for _, img := range illust.Images {
+		originals = append(originals, session.UnproxyImageUrl(r, img.Original))
+	}
+
+	// every page is a separate upstream download
+	if err := ChargeRequest(r, len(originals)); err != nil {
+		return err
+	}
+
+	return image_proxy.ServeArtworkArchive(w, r, illust, originals)
+}
+
 func PreloadImage(w http.ResponseWriter, url string) {
 	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=preload; as=image", url))
 }
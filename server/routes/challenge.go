@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+
+	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
+)
+
+// ChallengePage serves a proof-of-work challenge. The solution is submitted to /challenge by challenge.js.
+func ChallengePage(w http.ResponseWriter, r *http.Request, challenge string, difficulty int, redirect string) {
+	request_context.Get(r).RenderStatusCode = http.StatusForbidden
+	w.Header().Set("Cache-Control", "no-store")
+	err := RenderHTML(w, r, Data_challenge{
+		Title:      "Checking your browser",
+		Challenge:  challenge,
+		Difficulty: difficulty,
+		Redirect:   redirect,
+	})
+	if err != nil {
+		log.Printf("Error rendering challenge route: %s", err)
+	}
+}
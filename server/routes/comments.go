@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+)
+
+// commentWorkPaths maps comment targets to the path of the work's page
+var commentWorkPaths = map[string]string{
+	core.CommentTargetIllust: "/artworks/",
+	core.CommentTargetNovel:  "/novel/",
+}
+
+func ArtworkCommentsPage(w http.ResponseWriter, r *http.Request) error {
+	return commentsPage(w, r, core.CommentTargetIllust)
+}
+
+func NovelCommentsPage(w http.ResponseWriter, r *http.Request) error {
+	return commentsPage(w, r, core.CommentTargetNovel)
+}
+
+func ArtworkCommentRepliesPage(w http.ResponseWriter, r *http.Request) error {
+	return commentRepliesPage(w, r, core.CommentTargetIllust)
+}
+
+func NovelCommentRepliesPage(w http.ResponseWriter, r *http.Request) error {
+	return commentRepliesPage(w, r, core.CommentTargetNovel)
+}
+
+// commentsPage renders a batch of root comments. The artwork and novel pages load more of them from here.
+func commentsPage(w http.ResponseWriter, r *http.Request, target string) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	offset, err := strconv.Atoi(GetQueryParam(r, "offset", "0"))
+	if err != nil || offset < 0 {
+		return i18n.Error("Invalid offset.")
+	}
+
+	comments, hasNext, err := core.GetComments(r, target, id, offset)
+	if err != nil {
+		return err
+	}
+
+	data := Data_comments{
+		Title:    "Comments",
+		Target:   target,
+		WorkID:   id,
+		WorkURL:  commentWorkPaths[target] + id,
+		AuthorID: GetQueryParam(r, "author"),
+		Comments: comments,
+	}
+	if hasNext {
+		data.NextURL = commentsURL(data.WorkURL+"/comments", data.AuthorID, "offset", offset+len(comments))
+	}
+
+	return RenderHTML(w, r, data)
+}
+
+// commentRepliesPage renders a page of the replies to a root comment.
+func commentRepliesPage(w http.ResponseWriter, r *http.Request, target string) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	commentID := GetPathVar(r, "comment")
+	if _, err := strconv.Atoi(commentID); err != nil {
+		return i18n.Errorf("Invalid ID: %s", commentID)
+	}
+
+	page, err := strconv.Atoi(GetQueryParam(r, "page", "1"))
+	if err != nil || page < 1 {
+		return i18n.Error("Invalid page number.")
+	}
+
+	replies, hasNext, err := core.GetCommentReplies(r, target, commentID, page)
+	if err != nil {
+		return err
+	}
+
+	data := Data_comments{
+		Title:    "Replies",
+		Target:   target,
+		WorkID:   id,
+		WorkURL:  commentWorkPaths[target] + id,
+		AuthorID: GetQueryParam(r, "author"),
+		Comments: replies,
+		Replies:  true,
+	}
+	if hasNext {
+		data.NextURL = commentsURL(fmt.Sprintf("%s/comments/%s/replies", data.WorkURL, commentID), data.AuthorID, "page", page+1)
+	}
+
+	return RenderHTML(w, r, data)
+}
+
+// commentsURL builds the URL of the next batch of comments.
+// The work's author is passed along since posting a reply needs it.
+func commentsURL(path, authorID, key string, value int) string {
+	query := url.Values{}
+	if authorID != "" {
+		query.Set("author", authorID)
+	}
+	query.Set(key, strconv.Itoa(value))
+
+	return path + "?" + query.Encode()
+}
@@ -9,11 +9,16 @@ import (
 	"github.com/soluble-ai/go-jnode"
 
 	"codeberg.org/vnpower/pixivfe/v2/server/audit"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
 	"codeberg.org/vnpower/pixivfe/v2/server/utils"
 )
 
 func Diagnostics(w http.ResponseWriter, r *http.Request) error {
-	return RenderHTML(w, r, Data_diagnostics{})
+	stats, enabled := image_proxy.Stats()
+	return RenderHTML(w, r, Data_diagnostics{
+		ImageCacheEnabled: enabled,
+		ImageCache:        stats,
+	})
 }
 
 func ResetDiagnosticsData(w http.ResponseWriter, r *http.Request) {
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
 	"codeberg.org/vnpower/pixivfe/v2/server/template"
 )
 
@@ -14,10 +15,11 @@ func DiscoveryPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	works, hidden := core.GetFilter(r).Artworks(works)
 
 	urlc := template.PartialURL{Path: "discovery", Query: map[string]string{"mode": mode}}
 
-	return RenderHTML(w, r, Data_discovery{Artworks: works, Title: "Discovery", Queries: urlc})
+	return RenderHTML(w, r, Data_discovery{Artworks: works, Title: "Discovery", Queries: urlc, Hidden: hidden})
 }
 
 func NovelDiscoveryPage(w http.ResponseWriter, r *http.Request) error {
@@ -27,8 +29,36 @@ func NovelDiscoveryPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	works, hidden := core.GetFilter(r).Novels(works)
 
 	urlc := template.PartialURL{Path: "discovery/novel", Query: map[string]string{"mode": mode}}
 
-	return RenderHTML(w, r, Data_novelDiscovery{Novels: works, Title: "Discovery", Queries: urlc})
+	return RenderHTML(w, r, Data_novelDiscovery{Novels: works, Title: "Discovery", Queries: urlc, Hidden: hidden})
+}
+
+// recentWorksPerUser is how many recent works are shown with each recommended user
+const recentWorksPerUser = 4
+
+func UserDiscoveryPage(w http.ResponseWriter, r *http.Request) error {
+	if session.GetUserToken(r) == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	users, err := core.GetDiscoveryUsers(r, 30)
+	if err != nil {
+		return err
+	}
+	users, hidden := core.GetFilter(r).RecommendedUsers(users)
+	limitRecentWorks(users)
+
+	return RenderHTML(w, r, Data_userDiscovery{Users: users, Title: "Discovery", Hidden: hidden})
+}
+
+// limitRecentWorks keeps at most recentWorksPerUser of each user's recent works.
+func limitRecentWorks(users []core.RecommendedUser) {
+	for i := range users {
+		if len(users[i].Artworks) > recentWorksPerUser {
+			users[i].Artworks = users[i].Artworks[:recentWorksPerUser]
+		}
+	}
 }
@@ -3,10 +3,76 @@ package routes
 import (
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
 )
 
+// RouteClass groups routes that share a rate limit budget
+type RouteClass string
+
+const (
+	RouteClassPage   RouteClass = "page"   // page renders
+	RouteClassMulti  RouteClass = "multi"  // /artworks-multi, cost-weighted by the number of artworks
+	RouteClassSearch RouteClass = "search" // tag search
+	RouteClassProxy  RouteClass = "proxy"  // built-in image proxy
+	RouteClassAction RouteClass = "action" // bookmarks, likes, follows and settings
+)
+
+// RateLimitedError is returned by ChargeRequest when a request exceeds the budget of its route class.
+// HandleError responds to it with RateLimitedPage.
+type RateLimitedError struct {
+	Class      RouteClass
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return i18n.Sprintf("Too many requests. Try again in %d seconds.", int(e.RetryAfter.Seconds()))
+}
+
+// RequestTooLargeError is returned by ChargeRequest when a request costs more tokens than its route class has.
+// Unlike RateLimitedError, waiting does not help.
+type RequestTooLargeError struct {
+	Cost  int
+	Limit int
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return i18n.Sprintf("This is too large to download at once: it needs %d requests, but at most %d are allowed.", e.Cost, e.Limit)
+}
+
+// ChargeLimit returns how many more tokens can be charged with ChargeRequest,
+// and false if the rate limiter is disabled.
+func ChargeLimit(r *http.Request) (int, bool) {
+	ctx := request_context.Get(r)
+	if ctx.ChargeTokens == nil {
+		return 0, false
+	}
+	return int(ctx.ChargeLimit), true
+}
+
+// ChargeRequest takes cost more tokens from the rate limit budget of a request,
+// for work that depends on what the handler fetched, like the number of pages of an artwork.
+//
+// Handlers should work out the whole cost and call it once, before doing any of the work,
+// and return the error if there is one.
+func ChargeRequest(r *http.Request, cost int) error {
+	ctx := request_context.Get(r)
+	if ctx.ChargeTokens == nil || cost <= 0 {
+		return nil
+	}
+	if uint64(cost) > ctx.ChargeLimit {
+		return &RequestTooLargeError{Cost: cost, Limit: int(ctx.ChargeLimit)}
+	}
+	if err := ctx.ChargeTokens(uint64(cost)); err != nil {
+		return err
+	}
+	ctx.ChargeLimit -= uint64(cost)
+	return nil
+}
+
 func ErrorPage(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
 	request_context.Get(r).RenderStatusCode = statusCode
 	err = RenderHTML(w, r, Data_error{Title: "Error", Error: err})
@@ -14,3 +80,39 @@ func ErrorPage(w http.ResponseWriter, r *http.Request, err error, statusCode int
 		log.Printf("Error rendering error route: %s", err)
 	}
 }
+
+// RateLimitedPage responds with HTTP 429 for a request that exceeded the budget of its route class.
+//
+// Image proxy requests get a plain text response, since they are never displayed as a page.
+func RateLimitedPage(w http.ResponseWriter, r *http.Request, class RouteClass, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	if class == RouteClassProxy {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	var message string
+	switch class {
+	case RouteClassMulti:
+		message = i18n.Tr("You are viewing too many artworks at once. Try opening fewer artworks per page.")
+	case RouteClassSearch:
+		message = i18n.Tr("You are searching too quickly.")
+	case RouteClassAction:
+		message = i18n.Tr("You are performing actions too quickly.")
+	default:
+		message = i18n.Tr("You are sending requests too quickly.")
+	}
+
+	request_context.Get(r).RenderStatusCode = http.StatusTooManyRequests
+	err := RenderHTML(w, r, Data_rateLimited{
+		Title:      "Too many requests",
+		Class:      string(class),
+		Message:    message,
+		RetryAfter: seconds,
+	})
+	if err != nil {
+		log.Printf("Error rendering rate limited route: %s", err)
+	}
+}
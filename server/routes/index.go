@@ -17,6 +17,25 @@ func IndexPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	filter := core.GetFilter(r)
+	var hidden, n int
+	works.Commissions, n = filter.Artworks(works.Commissions)
+	hidden += n
+	works.Following, n = filter.Artworks(works.Following)
+	hidden += n
+	works.Recommended, n = filter.Artworks(works.Recommended)
+	hidden += n
+	works.Newest, n = filter.Artworks(works.Newest)
+	hidden += n
+	works.Rankings, n = filter.Ranking(works.Rankings)
+	hidden += n
+	for i := range works.RecommendByTags {
+		works.RecommendByTags[i].Artworks, n = filter.Artworks(works.RecommendByTags[i].Artworks)
+		hidden += n
+	}
+	works.Users, n = filter.RecommendedUsers(works.Users)
+	hidden += n
+	limitRecentWorks(works.Users)
 
 	urlc := template.PartialURL{Path: "", Query: map[string]string{"mode": mode}}
 
@@ -25,6 +44,7 @@ func IndexPage(w http.ResponseWriter, r *http.Request) error {
 		Data:     *works,
 		LoggedIn: isLoggedIn,
 		Queries:  urlc,
+		Hidden:   hidden,
 	})
 }
 
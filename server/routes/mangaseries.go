@@ -8,6 +8,8 @@ import (
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
 )
 
 func MangaSeriesPage(w http.ResponseWriter, r *http.Request) error {
@@ -59,7 +61,116 @@ func MangaSeriesPage(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// all works of a series are by the same user, who was opened on purpose
+	var hidden int
+	seriesContent.Series, hidden = core.GetFilter(r).WithoutMutedUsers().MangaSeriesWorks(seriesContent.Series)
+
 	title := fmt.Sprintf("%s / %s Series", seriesContent.Brief.Title, user.Name)
 
-	return RenderHTML(w, r, Data_mangaSeries{MangaSeriesContent: seriesContent, Title: title, User: user, Page: pageNum, PageLimit: pageLimit})
+	return RenderHTML(w, r, Data_mangaSeries{MangaSeriesContent: seriesContent, Title: title, User: user, Page: pageNum, PageLimit: pageLimit, Hidden: hidden})
+}
+
+const (
+	// maxComicChapters and maxComicPages limit the size of a CBZ download
+	maxComicChapters = 50
+	maxComicPages    = 1000
+)
+
+// MangaSeriesDownload streams a manga series as a CBZ with a ComicInfo.xml.
+// With the chapter query parameter (an artwork ID), only that work of the series is included.
+//
+// Every chapter and page is charged to the rate limiter, since each is a separate upstream request.
+// The cost is worked out from the page counts in the series listing, before any chapter is requested.
+func MangaSeriesDownload(w http.ResponseWriter, r *http.Request) error {
+	seriesId := GetPathVar(r, "sid")
+	if _, err := strconv.Atoi(seriesId); err != nil {
+		return i18n.Errorf("Invalid Series ID: %s", seriesId)
+	}
+	chapter := GetQueryParam(r, "chapter", "")
+	if chapter != "" {
+		if _, err := strconv.Atoi(chapter); err != nil {
+			return i18n.Errorf("Invalid ID: %s", chapter)
+		}
+	}
+
+	var series core.MangaSeries
+	var works []core.MangaSeriesWork
+	var chapterIllust *core.Illust
+	if chapter != "" {
+		// only the series details are needed, not the list of its works
+		seriesContent, err := core.GetMangaSeriesContentByID(r, seriesId, 1)
+		if err != nil {
+			return err
+		}
+		chapterIllust, err = core.GetArtworkByID(r, chapter, false)
+		if err != nil {
+			return err
+		}
+		if chapterIllust.SeriesNavData.SeriesID != seriesId {
+			return i18n.Errorf("Artwork %s is not part of series %s", chapter, seriesId)
+		}
+		series = seriesContent.Brief
+		works = []core.MangaSeriesWork{{WorkID: chapter, Order: chapterIllust.SeriesNavData.Order}}
+	} else {
+		seriesContent, err := core.GetAllMangaSeriesContent(r, seriesId, maxComicChapters)
+		if err != nil {
+			return err
+		}
+		series = seriesContent.Brief
+		works = seriesContent.Series
+	}
+
+	// a single chapter was already requested, so only its pages are left
+	var cost int
+	if chapter != "" {
+		cost = len(chapterIllust.Images)
+	} else {
+		pages := 0
+		for _, work := range works {
+			pages += max(work.Brief.Pages, 1)
+		}
+		if pages > maxComicPages {
+			return i18n.Errorf("Series %s has too many pages to download at once: %d (at most %d)", seriesId, pages, maxComicPages)
+		}
+		cost = len(works) + pages
+	}
+	if err := ChargeRequest(r, cost); err != nil {
+		return err
+	}
+
+	var chapters []image_proxy.ComicChapter
+	pages := 0
+	for _, work := range works {
+		illust := chapterIllust
+		if chapter == "" {
+			var err error
+			illust, err = core.GetArtworkByID(r, work.WorkID, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		// a work can have gained pages since the listing was made
+		pages += len(illust.Images)
+		if pages > maxComicPages {
+			return i18n.Errorf("Series %s has too many pages to download at once (at most %d)", seriesId, maxComicPages)
+		}
+
+		originals := make([]string, 0, len(illust.Images))
+		for _, img := range illust.Images {
+			originals = append(originals, session.UnproxyImageUrl(r, img.Original))
+		}
+		chapters = append(chapters, image_proxy.ComicChapter{Illust: illust, Order: work.Order, Originals: originals})
+	}
+	if len(chapters) == 0 {
+		return i18n.Errorf("Series %s has no works", seriesId)
+	}
+
+	filename := "series_" + seriesId
+	if chapter != "" {
+		filename = fmt.Sprintf("series_%s_%d", seriesId, chapters[0].Order)
+	}
+
+	info := image_proxy.NewComicInfo(series, chapters)
+	return image_proxy.ServeComicArchive(w, r, filename, info, chapters)
 }
@@ -15,6 +15,7 @@ func NewestPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	works, hidden := core.GetFilter(r).Artworks(works)
 
-	return RenderHTML(w, r, Data_newest{Items: works, Title: "Newest works"})
+	return RenderHTML(w, r, Data_newest{Items: works, Title: "Newest works", Hidden: hidden})
 }
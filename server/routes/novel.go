@@ -2,13 +2,18 @@ package routes
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/epub"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
 )
 
 func NovelPage(w http.ResponseWriter, r *http.Request) error {
@@ -26,19 +31,37 @@ func NovelPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	filter := core.GetFilter(r)
+	related, hidden := filter.Novels(related)
+
+	// the author's other novels. Muted users are not hidden, since this is their novel
+	userNovels := len(novel.UserNovels)
+	maps.DeleteFunc(novel.UserNovels, func(_ string, brief *core.NovelBrief) bool {
+		return filter.WithoutMutedUsers().HidesNovel(*brief)
+	})
+	hidden += userNovels - len(novel.UserNovels)
 
 	var contentTitles []core.NovelSeriesContentTitle
+	var series core.NovelSeries
+	var glossary core.NovelGlossary
 	if novel.SeriesNavData.SeriesID != 0 {
+		seriesID := strconv.Itoa(novel.SeriesNavData.SeriesID)
+
 		// Must use token, because we can't determine Series' XRestrict via Novel API here
 		// and All-age post could also appears in R-18 series.
 		contentTitles, _ = core.GetNovelSeriesContentTitlesByID(r, novel.SeriesNavData.SeriesID)
+		series, _ = core.GetNovelSeriesByID(r, seriesID)
+
+		if novel.HasGlossary || series.HasGlossary {
+			glossary, _ = core.GetNovelSeriesGlossary(r, seriesID)
+		}
 	}
 
 	if novel.CommentOff == 0 {
-		// TODO should use token only if R-18/R-18G
-		comments, err := core.GetNovelComments(r, id)
+		comments, hasNext, err := core.GetComments(r, core.CommentTargetNovel, id, 0)
 		if err == nil {
 			novel.CommentsList = comments
+			novel.CommentsHasNext = hasNext
 		}
 	}
 
@@ -63,11 +86,15 @@ func NovelPage(w http.ResponseWriter, r *http.Request) error {
 		title = fmt.Sprintf("#%d %s | %s", novel.SeriesNavData.Order, novel.Title, novel.SeriesNavData.Title)
 	}
 
+	seriesPosition := 0
 	novelSeriesIDs := make([]string, len(contentTitles))
 	novelSeriesTitles := make([]string, len(contentTitles))
 	for i, ct := range contentTitles {
 		novelSeriesIDs[i] = ct.ID
 		novelSeriesTitles[i] = fmt.Sprintf("#%d %s", i+1, ct.Title)
+		if ct.ID == novel.ID {
+			seriesPosition = i + 1
+		}
 	}
 
 	return RenderHTML(w, r, Data_novel{
@@ -77,9 +104,66 @@ func NovelPage(w http.ResponseWriter, r *http.Request) error {
 		NovelSeriesContentTitles: contentTitles,
 		NovelSeriesIDs:           novelSeriesIDs,
 		NovelSeriesTitles:        novelSeriesTitles,
+		NovelSeries:              series,
+		SeriesPosition:           seriesPosition,
+		Glossary:                 glossary,
 		Title:                    title,
 		FontType:                 fontType,
 		ViewMode:                 viewMode,
 		Language:                 strings.ToLower(novel.Language),
+		Hidden:                   hidden,
+	})
+}
+
+// NovelEpub streams a novel as an EPUB 3 book.
+func NovelEpub(w http.ResponseWriter, r *http.Request) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	novel, err := core.GetNovelByID(r, id)
+	if err != nil {
+		return err
+	}
+
+	tags := make([]string, 0, len(novel.Tags.Tags))
+	for _, tag := range novel.Tags.Tags {
+		tags = append(tags, tag.Name)
+	}
+
+	book := epub.Book{
+		Identifier:  "urn:pixiv:novel:" + novel.ID,
+		Title:       novel.Title,
+		Author:      novel.UserName,
+		Language:    novel.Language,
+		Description: utils.HTMLToText(novel.Description),
+		Tags:        tags,
+		Date:        novel.CreateDate,
+		Modified:    novel.UploadDate,
+		Vertical:    novelVertical(r, novel),
+		CoverURL:    novel.CoverURL,
+	}
+	book.AddNovel(novel, novel.Title)
+
+	return writeEpub(w, r, &book, "novel_"+novel.ID)
+}
+
+// novelVertical reports whether a novel should be typeset vertically, according to the user's setting or else the author's.
+func novelVertical(r *http.Request, novel core.Novel) bool {
+	viewMode := session.GetCookie(r, session.Cookie_NovelViewMode)
+	if viewMode == "" {
+		viewMode = strconv.Itoa(novel.Settings.ViewMode)
+	}
+	return viewMode == "2"
+}
+
+// writeEpub streams book to the client. Images are fetched from i.pximg.net, whatever image proxy the user has chosen.
+func writeEpub(w http.ResponseWriter, r *http.Request, book *epub.Book, filename string) error {
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.epub"`, filename))
+
+	return book.Write(w, func(url string) (io.ReadCloser, error) {
+		return image_proxy.FetchImage(r.Context(), session.UnproxyImageUrl(r, url))
 	})
 }
@@ -8,6 +8,8 @@ import (
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/epub"
+	"codeberg.org/vnpower/pixivfe/v2/server/utils"
 )
 
 func NovelSeriesPage(w http.ResponseWriter, r *http.Request) error {
@@ -42,7 +44,86 @@ func NovelSeriesPage(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// all novels of a series are by the same user, who was opened on purpose
+	var hidden int
+	seriesContents, hidden = core.GetFilter(r).WithoutMutedUsers().NovelSeriesContents(seriesContents)
+
+	var glossary core.NovelGlossary
+	if series.HasGlossary {
+		glossary, _ = core.GetNovelSeriesGlossary(r, id)
+	}
+
 	title := fmt.Sprintf("%s | %s", series.Title, series.UserName)
 
-	return RenderHTML(w, r, Data_novelSeries{NovelSeries: series, NovelSeriesContents: seriesContents, Title: title, User: user, Page: pageNum, PageLimit: pageLimit})
+	return RenderHTML(w, r, Data_novelSeries{NovelSeries: series, NovelSeriesContents: seriesContents, Glossary: glossary, Title: title, User: user, Page: pageNum, PageLimit: pageLimit, Hidden: hidden})
+}
+
+// maxEpubSeriesNovels limits how many novels of a series can be put into one EPUB
+const maxEpubSeriesNovels = 100
+
+// NovelSeriesEpub streams every novel of a series as one EPUB 3 book, in series order.
+//
+// Every novel is charged to the rate limiter, since each is a separate upstream request.
+func NovelSeriesEpub(w http.ResponseWriter, r *http.Request) error {
+	id := GetPathVar(r, "id")
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	series, err := core.GetNovelSeriesByID(r, id)
+	if err != nil {
+		return err
+	}
+
+	if series.Total > maxEpubSeriesNovels {
+		return i18n.Errorf("Novel series %s has too many novels to download at once: %d (at most %d)", id, series.Total, maxEpubSeriesNovels)
+	}
+	if err := ChargeRequest(r, series.Total); err != nil {
+		return err
+	}
+
+	perPage := 30
+	var contents []core.NovelSeriesContent
+	for page := 1; len(contents) < series.Total; page++ {
+		pageContents, err := core.GetNovelSeriesContentByID(r, id, page, perPage)
+		if err != nil {
+			return err
+		}
+		if len(pageContents) == 0 {
+			break
+		}
+		contents = append(contents, pageContents...)
+	}
+	if len(contents) == 0 {
+		return i18n.Errorf("Novel series %s has no novels", id)
+	}
+	contents = contents[:min(len(contents), series.Total)]
+
+	book := epub.Book{
+		Identifier:  "urn:pixiv:novel-series:" + series.ID,
+		Title:       series.Title,
+		Author:      series.UserName,
+		Language:    series.Language,
+		Description: utils.HTMLToText(series.Caption),
+		Tags:        series.Tags,
+		Date:        series.CreateDate,
+		Modified:    series.UpdateDate,
+		CoverURL:    series.Cover.Urls.Original,
+	}
+
+	for i, content := range contents {
+		novel, err := core.GetNovelByID(r, content.ID)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			book.Vertical = novelVertical(r, novel)
+			if book.Language == "" {
+				book.Language = novel.Language
+			}
+		}
+		book.AddNovel(novel, fmt.Sprintf("#%d %s", content.Series.ContentOrder, novel.Title))
+	}
+
+	return writeEpub(w, r, &book, "novel_series_"+series.ID)
 }
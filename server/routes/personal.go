@@ -1,11 +1,13 @@
 package routes
 
 import (
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
 	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
 )
@@ -33,6 +35,7 @@ func LoginUserPage(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// LoginBookmarkPage lets the user manage their own bookmarks, public and private.
 func LoginBookmarkPage(w http.ResponseWriter, r *http.Request) error {
 	token := session.GetUserToken(r)
 	if token == "" {
@@ -40,10 +43,81 @@ func LoginBookmarkPage(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// The left part of the token is the member ID
-	userId := strings.Split(token, "_")
+	userId := strings.Split(token, "_")[0]
 
-	http.Redirect(w, r, "/users/"+userId[0]+"/bookmarks#checkpoint", http.StatusSeeOther)
-	return nil
+	rest := GetQueryParam(r, "rest", "show")
+	if rest != "show" && rest != "hide" {
+		return i18n.Errorf("Invalid bookmark visibility: %s", rest)
+	}
+	tag := GetQueryParam(r, "tag")
+
+	page, err := strconv.Atoi(GetQueryParam(r, "page", "1"))
+	if err != nil || page < 1 {
+		return i18n.Error("Invalid page number.")
+	}
+
+	artworks, total, err := core.GetUserBookmarks(r, userId, rest, tag, page)
+	if err != nil {
+		return err
+	}
+	// the user's own bookmarks aren't filtered, so that all of them can be managed
+
+	tags, err := core.GetUserBookmarkTags(r, userId, "illusts")
+	if err != nil {
+		return err
+	}
+	restTags := tags.Public
+	if rest == "hide" {
+		restTags = tags.Private
+	}
+
+	pageLimit := max(1, int(math.Ceil(float64(total)/float64(core.BookmarksPerPage))))
+
+	return RenderHTML(w, r, Data_bookmarks{
+		Title:     "Your bookmarks",
+		Artworks:  artworks,
+		Total:     total,
+		Rest:      rest,
+		Tag:       tag,
+		Tags:      restTags,
+		Page:      page,
+		PageLimit: pageLimit,
+	})
+}
+
+// BookmarkTagsPage renders the tags the user gave to their artwork bookmarks as a fragment, without the layout.
+// The bookmark form of artwork pages loads it once when it is opened, to suggest tags.
+//
+// The browser may reuse it for a few minutes, so that opening the form on other artworks doesn't ask Pixiv again.
+func BookmarkTagsPage(w http.ResponseWriter, r *http.Request) error {
+	token := session.GetUserToken(r)
+	if token == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	// The left part of the token is the member ID
+	userId := strings.Split(token, "_")[0]
+
+	tags, err := core.GetUserBookmarkTags(r, userId, "illusts")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	return RenderHTML(w, r, Data_bookmarkTags{Tags: tags.Names()})
+}
+
+// SelfFollowingPage lists the users the logged in user follows, publicly or privately.
+func SelfFollowingPage(w http.ResponseWriter, r *http.Request) error {
+	token := session.GetUserToken(r)
+	if token == "" {
+		return PromptUserToLoginPage(w, r)
+	}
+
+	// The left part of the token is the member ID
+	userId := strings.Split(token, "_")[0]
+
+	return userFollowsPage(w, r, userId, "following")
 }
 
 func FollowingWorksPage(w http.ResponseWriter, r *http.Request) error {
@@ -63,6 +137,7 @@ func FollowingWorksPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	works, hidden := core.GetFilter(r).Artworks(works)
 
-	return RenderHTML(w, r, Data_following{Title: "Following works", Mode: mode, Artworks: works, CurPage: page, Page: pageInt})
+	return RenderHTML(w, r, Data_following{Title: "Following works", Mode: mode, Artworks: works, CurPage: page, Page: pageInt, Hidden: hidden})
 }
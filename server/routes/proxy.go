@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
 )
 
 func SPximgProxy(w http.ResponseWriter, r *http.Request) error {
@@ -13,8 +15,10 @@ func SPximgProxy(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	core.ProxyRequest(w, req)
-	return nil
+	if image_proxy.CacheEnabled() {
+		return image_proxy.ServeCached(w, r, req)
+	}
+	return core.ProxyRequest(w, r, req)
 }
 
 func IPximgProxy(w http.ResponseWriter, r *http.Request) error {
@@ -24,16 +28,20 @@ func IPximgProxy(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	req.Header.Add("Referer", "https://www.pixiv.net/")
-	core.ProxyRequest(w, req)
-	return nil
+	if opts, ok := image_proxy.ParseResizeOptions(r); ok {
+		return image_proxy.ServeResized(w, r, req, opts)
+	}
+	if image_proxy.CacheEnabled() {
+		return image_proxy.ServeCached(w, r, req)
+	}
+	return core.ProxyRequest(w, r, req)
 }
 
-func UgoiraProxy(w http.ResponseWriter, r *http.Request) error {
-	URL := fmt.Sprintf("https://ugoira.com/api/mp4/%s", r.URL.Path)
-	req, err := http.NewRequestWithContext(r.Context(), "GET", URL, nil)
-	if err != nil {
-		return err
-	}
-	core.ProxyRequest(w, req)
-	return nil
+// SmartPximgProxy serves i.pximg.net images through the user's image proxy,
+// failing over to other working proxies and finally to IPximgProxy.
+func SmartPximgProxy(w http.ResponseWriter, r *http.Request) error {
+	preferred := session.GetImageProxy(r)
+	return image_proxy.ServeFailover(w, r, r.URL.Path, preferred.String(), func(w http.ResponseWriter) error {
+		return IPximgProxy(w, r)
+	})
 }
@@ -22,6 +22,7 @@ func RankingPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	works, hidden := core.GetFilter(r).Ranking(works)
 
-	return RenderHTML(w, r, Data_rank{Title: "Ranking", Page: pageInt, PageLimit: 10, Date: date, Data: works})
+	return RenderHTML(w, r, Data_rank{Title: "Ranking", Page: pageInt, PageLimit: 10, Date: date, Data: works, Hidden: hidden})
 }
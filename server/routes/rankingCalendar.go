@@ -89,6 +89,7 @@ func RankingCalendarPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	calendar, hidden := core.GetFilter(r).RankingCalendar(mode, calendar)
 
 	// Prepare and render the template with the calendar data
 	return RenderHTML(w, r, Data_rankingCalendar{
@@ -98,6 +99,7 @@ func RankingCalendarPage(w http.ResponseWriter, r *http.Request) error {
 		Year:        year,
 		MonthBefore: parseDate(monthBefore),
 		MonthAfter:  parseDate(monthAfter),
+		Hidden:      hidden,
 		ThisMonth:   parseDate(realDate),
 	})
 }
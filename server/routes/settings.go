@@ -2,15 +2,22 @@ package routes
 
 import (
 	"bufio"
+	"context"
+	"html"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/goccy/go-json"
+
 	"codeberg.org/vnpower/pixivfe/v2/config"
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
 	"codeberg.org/vnpower/pixivfe/v2/server/proxy_checker"
 	"codeberg.org/vnpower/pixivfe/v2/server/session"
 	"codeberg.org/vnpower/pixivfe/v2/server/utils"
@@ -59,9 +66,26 @@ func setImageServer(w http.ResponseWriter, r *http.Request) (string, error) {
 	customProxy := r.FormValue("custom-image-proxy")
 	selectedProxy := r.FormValue("image-proxy")
 
+	if r.FormValue("smart-proxy") == "true" {
+		session.SetCookie(w, session.Cookie_SmartProxy, "true")
+	} else {
+		session.ClearCookie(w, session.Cookie_SmartProxy)
+	}
+
 	if customProxy != "" {
-		session.SetCookie(w, session.Cookie_ImageProxy, customProxy)
-		return i18n.Sprintf("Custom image proxy server set successfully."), nil
+		proxyURL, err := normalizeProxyURL(customProxy)
+		if err != nil {
+			return "", err
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), config.GlobalConfig.ProxyCheckTimeout)
+		defer cancel()
+		if err := proxy_checker.VerifyProxy(ctx, proxyURL); err != nil {
+			return "", i18n.Errorf("Custom image proxy server %s is not working: %w", proxyURL, err)
+		}
+
+		session.SetCookie(w, session.Cookie_ImageProxy, proxyURL)
+		return i18n.Sprintf("Custom image proxy server %s set successfully.", proxyURL), nil
 	} else if selectedProxy != "" {
 		session.SetCookie(w, session.Cookie_ImageProxy, selectedProxy)
 		return i18n.Sprintf("Image proxy server updated successfully."), nil
@@ -71,6 +95,41 @@ func setImageServer(w http.ResponseWriter, r *http.Request) (string, error) {
 	}
 }
 
+// normalizeProxyURL checks that a user supplied image proxy URL is an absolute http(s) URL,
+// and strips what we don't need from it.
+func normalizeProxyURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil || proxyURL.Host == "" {
+		return "", i18n.Errorf("Invalid image proxy server URL: %s", raw)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return "", i18n.Errorf("Image proxy server URL must start with http:// or https://: %s", raw)
+	}
+
+	proxyURL.Host = strings.ToLower(proxyURL.Host)
+	proxyURL.Path = strings.TrimRight(proxyURL.Path, "/")
+	proxyURL.User = nil
+	proxyURL.RawQuery = ""
+	proxyURL.Fragment = ""
+	proxyURL.RawPath = ""
+	return proxyURL.String(), nil
+}
+
+func setImageProfile(w http.ResponseWriter, r *http.Request) (string, error) {
+	profile := r.FormValue("image-profile")
+	if _, ok := image_proxy.Profiles[profile]; ok || profile == "" {
+		session.SetCookie(w, session.Cookie_ImageProfile, profile)
+		return i18n.Sprintf("Image quality updated successfully."), nil
+	}
+
+	return "", i18n.Error("Invalid image quality.")
+}
+
 func setNovelFontType(w http.ResponseWriter, r *http.Request) (string, error) {
 	fontType := r.FormValue("font-type")
 	if fontType != "" {
@@ -124,6 +183,193 @@ func setFilter(w http.ResponseWriter, r *http.Request) (string, error) {
 	return i18n.Sprintf("Filter settings updated successfully."), nil
 }
 
+// Cookies are limited to about 4 KB each
+const maxMuteCookieLength = 3800
+
+// normalizeMuteList trims and deduplicates a mute list, and checks that it fits in the cookies.
+func normalizeMuteList(tags, users []string) (core.MuteList, error) {
+	var list core.MuteList
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !list.MutesTag(tag) {
+			list.Tags = append(list.Tags, tag)
+		}
+	}
+	for _, user := range users {
+		user = strings.TrimSpace(user)
+		if user == "" || list.MutesUser(user) {
+			continue
+		}
+		if _, err := strconv.Atoi(user); err != nil {
+			return core.MuteList{}, i18n.Errorf("Invalid user ID: %s", user)
+		}
+		list.Users = append(list.Users, user)
+	}
+
+	if len(url.QueryEscape(strings.Join(list.Tags, "\n"))) > maxMuteCookieLength ||
+		len(url.QueryEscape(strings.Join(list.Users, "\n"))) > maxMuteCookieLength {
+		return core.MuteList{}, i18n.Error("The mute list is too long.")
+	}
+	return list, nil
+}
+
+func saveMuteList(w http.ResponseWriter, list core.MuteList) {
+	session.SetCookieList(w, session.Cookie_MutedTags, list.Tags)
+	session.SetCookieList(w, session.Cookie_MutedUsers, list.Users)
+}
+
+// setMuteList replaces the mute list. Tags and user IDs are given one per line.
+func setMuteList(w http.ResponseWriter, r *http.Request) (string, error) {
+	list, err := normalizeMuteList(strings.Split(r.FormValue("muted-tags"), "\n"), strings.Split(r.FormValue("muted-users"), "\n"))
+	if err != nil {
+		return "", err
+	}
+	saveMuteList(w, list)
+
+	return i18n.Sprintf("Mute list updated successfully."), nil
+}
+
+// setMuteItem mutes or unmutes ("remove" set) a single tag or user, for the buttons on tag and user pages.
+func setMuteItem(w http.ResponseWriter, r *http.Request) (string, error) {
+	value := strings.TrimSpace(r.FormValue("value"))
+	if value == "" {
+		return "", i18n.Error("You submitted an empty/invalid form.")
+	}
+	remove := r.FormValue("remove") == "true"
+
+	list := core.GetMuteList(r)
+	tags, users := list.Tags, list.Users
+	switch r.FormValue("type") {
+	case core.MuteItemTag:
+		if remove {
+			tags = slices.DeleteFunc(tags, func(tag string) bool { return strings.EqualFold(tag, value) })
+		} else {
+			tags = append(tags, value)
+		}
+	case core.MuteItemUser:
+		if remove {
+			users = slices.DeleteFunc(users, func(user string) bool { return user == value })
+		} else {
+			users = append(users, value)
+		}
+	default:
+		return "", i18n.Error("You submitted an empty/invalid form.")
+	}
+
+	list, err := normalizeMuteList(tags, users)
+	if err != nil {
+		return "", err
+	}
+	saveMuteList(w, list)
+
+	if remove {
+		return i18n.Sprintf("Unmuted %s.", value), nil
+	}
+	return i18n.Sprintf("Muted %s.", value), nil
+}
+
+// importPixivMuteList adds the mute settings of the user's Pixiv account to the mute list.
+func importPixivMuteList(w http.ResponseWriter, r *http.Request) (string, error) {
+	if session.GetUserToken(r) == "" {
+		return "", i18n.Error("You need to be logged in to import your Pixiv mute settings.")
+	}
+
+	items, err := core.GetPixivMuteList(r)
+	if err != nil {
+		return "", err
+	}
+
+	list := core.GetMuteList(r)
+	tags, users := list.Tags, list.Users
+	for _, item := range items {
+		switch item.Type {
+		case core.MuteItemTag:
+			tags = append(tags, item.Value)
+		case core.MuteItemUser:
+			users = append(users, item.Value)
+		}
+	}
+
+	list, err = normalizeMuteList(tags, users)
+	if err != nil {
+		return "", err
+	}
+	saveMuteList(w, list)
+
+	return i18n.Sprintf("Imported %d items from Pixiv.", len(items)), nil
+}
+
+// maxMuteSyncItems limits how many items syncPixivMuteList adds to Pixiv at once, since each is a separate request
+const maxMuteSyncItems = 20
+
+// syncPixivMuteList adds the items of the mute list that the user's Pixiv account doesn't have yet to it,
+// at most maxMuteSyncItems at a time and no more than the rate limiter allows. Every item is charged to it.
+// Pixiv only enables one of them for accounts without Premium.
+func syncPixivMuteList(_ http.ResponseWriter, r *http.Request) (string, error) {
+	token := session.GetUserToken(r)
+	csrf := session.GetCookie(r, session.Cookie_CSRF)
+	if token == "" || csrf == "" {
+		return "", i18n.Error("You need to be logged in to save your mute list to Pixiv.")
+	}
+
+	items, err := core.GetPixivMuteList(r)
+	if err != nil {
+		return "", err
+	}
+	onPixiv := make(map[string]bool, len(items))
+	for _, item := range items {
+		onPixiv[item.Type+"\n"+item.Value] = true
+	}
+
+	list := core.GetMuteList(r)
+	var local []core.PixivMuteItem
+	for _, tag := range list.Tags {
+		local = append(local, core.PixivMuteItem{Type: core.MuteItemTag, Value: tag})
+	}
+	for _, user := range list.Users {
+		local = append(local, core.PixivMuteItem{Type: core.MuteItemUser, Value: user})
+	}
+
+	var missing []core.PixivMuteItem
+	for _, item := range local {
+		if !onPixiv[item.Type+"\n"+item.Value] {
+			missing = append(missing, item)
+		}
+	}
+	batch := maxMuteSyncItems
+	if limit, limited := ChargeLimit(r); limited {
+		batch = min(batch, limit)
+	}
+	remaining := max(len(missing)-batch, 0)
+	missing = missing[:min(len(missing), batch)]
+
+	if err := ChargeRequest(r, len(missing)); err != nil {
+		return "", err
+	}
+
+	added := 0
+	for _, item := range missing {
+		payload, err := json.Marshal(map[string]string{"context": "setting", "type": item.Type, "value": item.Value})
+		if err != nil {
+			return "", err
+		}
+		_, err = core.API_POST(r.Context(), core.GetMuteItemsAddURL(), string(payload), token, csrf, "application/json; charset=utf-8")
+		if err != nil {
+			return "", i18n.Errorf("Saved %d items to Pixiv, then failed: %w", added, err)
+		}
+		added++
+	}
+
+	message := i18n.Sprintf("Saved %d items to Pixiv.", added)
+	if remaining > 0 {
+		message += " " + i18n.Sprintf("%d more items are left; save again to add them.", remaining)
+	}
+	if added > 0 {
+		message += " " + i18n.Tr("Without Pixiv Premium, Pixiv keeps all but one muted item disabled; you can choose which one in Pixiv's settings.")
+	}
+	return message, nil
+}
+
 func setLogout(w http.ResponseWriter, _ *http.Request) (string, error) {
 	session.ClearCookie(w, session.Cookie_Token)
 	session.ClearCookie(w, session.Cookie_CSRF)
@@ -193,21 +439,38 @@ func resetAll(w http.ResponseWriter, _ *http.Request) (string, error) {
 func SettingsPage(w http.ResponseWriter, r *http.Request) error {
 	return RenderHTML(w, r, Data_settings{
 		WorkingProxyList:   proxy_checker.GetWorkingProxies(),
-		ProxyList:          config.BuiltinProxyList,
+		ProxyList:          proxy_checker.ProxyList(),
+		ProxyStats:         proxy_checker.RankedProxies(),
+		SuggestedProxy:     proxy_checker.SuggestedProxy(),
 		ProxyCheckEnabled:  config.GlobalConfig.ProxyCheckEnabled,    // Used to check whether proxy_checker is enabled on the instance
 		ProxyCheckInterval: config.GlobalConfig.ProxyCheckInterval,   // Used to display the ProxyCheckInterval configured on the instance
 		DefaultProxyServer: config.GlobalConfig.ProxyServer.String(), // Used to display the default image proxy server
+		ImageResizeEnabled: config.GlobalConfig.ImageResizeEnabled,   // Used to check whether the built-in proxy can resize images
+		MuteList:           core.GetMuteList(r),
+	})
+}
+
+// ProxyStatsData serves the proxy checker statistics as JSON, best proxy first.
+func ProxyStatsData(w http.ResponseWriter, _ *http.Request) error {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	return json.NewEncoder(w).Encode(map[string]any{
+		"ProxyCheckEnabled": config.GlobalConfig.ProxyCheckEnabled,
+		"SuggestedProxy":    proxy_checker.SuggestedProxy(),
+		"Proxies":           proxy_checker.RankedProxies(),
 	})
 }
 
+// handleAjaxResponse writes the HTMX response of a settings form.
+// Messages are escaped, since they may contain user input.
 func handleAjaxResponse(w http.ResponseWriter, message string, err error) {
 	w.Header().Set("Content-Type", "text/html")
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(i18n.Sprintf(`<div class="alert alert-danger alert-dismissible fade show mt-3" role="alert">%s<button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button></div>`, err.Error())))
+		w.Write([]byte(i18n.Sprintf(`<div class="alert alert-danger alert-dismissible fade show mt-3" role="alert">%s<button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button></div>`, html.EscapeString(err.Error()))))
 	} else {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(i18n.Sprintf(`<div class="alert alert-success alert-dismissible fade show mt-3" role="alert">%s<button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button></div>`, message)))
+		w.Write([]byte(i18n.Sprintf(`<div class="alert alert-success alert-dismissible fade show mt-3" role="alert">%s<button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button></div>`, html.EscapeString(message))))
 	}
 }
 
@@ -219,6 +482,8 @@ func SettingsPost(w http.ResponseWriter, r *http.Request) error {
 	switch t {
 	case "imageServer":
 		message, err = setImageServer(w, r)
+	case "imageProfile":
+		message, err = setImageProfile(w, r)
 	case "token":
 		message, err = setToken(w, r)
 	case "logout":
@@ -235,6 +500,14 @@ func SettingsPost(w http.ResponseWriter, r *http.Request) error {
 		message, err = setArtworkPreview(w, r)
 	case "filter":
 		message, err = setFilter(w, r)
+	case "mute":
+		message, err = setMuteList(w, r)
+	case "muteItem":
+		message, err = setMuteItem(w, r)
+	case "muteImport":
+		message, err = importPixivMuteList(w, r)
+	case "muteSync":
+		message, err = syncPixivMuteList(w, r)
 	case "set-cookie":
 		message, err = setCookie(w, r)
 	case "clear-cookie":
@@ -49,6 +49,12 @@ func TagPage(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	filter := core.GetFilter(r)
+	var hidden int
+	result.Artworks.Artworks, hidden = filter.Artworks(result.Artworks.Artworks)
+	// the popular works are not counted, since they are not part of the results
+	result.Popular.Permanent, _ = filter.Artworks(result.Popular.Permanent)
+	result.Popular.Recent, _ = filter.Artworks(result.Popular.Recent)
 
 	urlc := template.PartialURL{Path: "tags", Query: queries.ReturnMap()}
 	data := Data_tag{
@@ -63,6 +69,8 @@ func TagPage(w http.ResponseWriter, r *http.Request) error {
 		ActiveMode:       queries.Mode,
 		ActiveRatio:      queries.Ratio,
 		ActiveSearchMode: GetQueryParam(r, "smode", ""),
+		Muted:            filter.Mute.MutesTag(tag.Name),
+		Hidden:           hidden,
 	}
 	return RenderHTML(w, r, data)
 }
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
+	"codeberg.org/vnpower/pixivfe/v2/server/proxy_checker"
 	"codeberg.org/vnpower/pixivfe/v2/server/request_context"
 	"codeberg.org/vnpower/pixivfe/v2/server/template"
 )
@@ -43,26 +45,81 @@ type Data_artwork struct {
 	MetaImage       string
 	MetaAuthor      string
 	MetaAuthorID    string
+	Hidden          int // related and recent works hidden by the user's filters
 }
 type Data_artworkMulti struct {
 	Artworks []core.Illust
 	Title    string
 }
+type Data_bookmarkTags struct {
+	Tags []string // the user's bookmark tags, most used first
+}
+type Data_bookmarks struct {
+	Title     string
+	Artworks  []core.ArtworkBrief
+	Total     int
+	Rest      string // "show" for public bookmarks, "hide" for private ones
+	Tag       string
+	Tags      []core.BookmarkTag
+	Page      int
+	PageLimit int
+}
+type Data_challenge struct {
+	Title      string
+	Challenge  string
+	Difficulty int
+	Redirect   string
+}
+type Data_comments struct {
+	Title    string
+	Target   string // core.CommentTargetIllust or core.CommentTargetNovel
+	WorkID   string
+	WorkURL  string
+	AuthorID string // the work's author, needed to post replies
+	Comments []core.Comment
+	Replies  bool   // whether Comments are replies to a root comment
+	NextURL  string // where to load more comments from, if there are more
+}
 type Data_discovery struct {
 	Artworks []core.ArtworkBrief
 	Title    string
 	Queries  template.PartialURL
+	Hidden   int
+}
+type Data_userDiscovery struct {
+	Users  []core.RecommendedUser
+	Title  string
+	Hidden int
 }
 type Data_error struct {
 	Title string
 	Error error
 }
+type Data_rateLimited struct {
+	Title      string
+	Class      string
+	Message    string
+	RetryAfter int
+}
 type Data_following struct {
 	Title    string
 	Mode     string
 	Artworks []core.ArtworkBrief
 	CurPage  string
 	Page     int
+	Hidden   int
+}
+type Data_userFollows struct {
+	Title     string
+	User      core.UserBrief // whose follows are listed
+	Kind      string         // "following" or "followers"
+	Users     []core.FollowUser
+	Total     int
+	Rest      string // "show" for public follows, "hide" for private ones
+	Self      bool   // whether User is the logged in user
+	Page      int
+	PageLimit int
+	Hidden    int
 }
 type Data_index struct {
 	Title       string
@@ -70,10 +127,12 @@ type Data_index struct {
 	Data        core.LandingArtworks
 	NoTokenData core.Ranking
 	Queries     template.PartialURL
+	Hidden      int // works hidden by the user's filters, in all sections
 }
 type Data_newest struct {
-	Items []core.ArtworkBrief
-	Title string
+	Items  []core.ArtworkBrief
+	Title  string
+	Hidden int
 }
 type Data_novel struct {
 	Novel                    core.Novel
@@ -81,24 +140,31 @@ type Data_novel struct {
 	NovelSeriesContentTitles []core.NovelSeriesContentTitle
 	NovelSeriesIDs           []string
 	NovelSeriesTitles        []string
+	NovelSeries              core.NovelSeries
+	SeriesPosition           int // position of the novel in its series, starting at 1. 0 if unknown
+	Glossary                 core.NovelGlossary
 	User                     core.UserBrief
 	Title                    string
 	FontType                 string
 	ViewMode                 string
 	Language                 string
+	Hidden                   int // related and other novels hidden by the user's filters
 }
 type Data_novelSeries struct {
 	NovelSeries         core.NovelSeries
 	NovelSeriesContents []core.NovelSeriesContent
+	Glossary            core.NovelGlossary
 	User                core.UserBrief
 	Title               string
 	Page                int
 	PageLimit           int
+	Hidden              int
 }
 type Data_novelDiscovery struct {
 	Novels  []core.NovelBrief
 	Title   string
 	Queries template.PartialURL
+	Hidden  int
 }
 type Data_pixivisionIndex struct {
 	Data []core.PixivisionArticle
@@ -122,6 +188,7 @@ type Data_rank struct {
 	PageLimit int
 	Date      string
 	Data      core.Ranking
+	Hidden    int
 }
 type Data_rankingCalendar struct {
 	Title       string
@@ -131,13 +198,18 @@ type Data_rankingCalendar struct {
 	MonthBefore DateWrap
 	MonthAfter  DateWrap
 	ThisMonth   DateWrap
+	Hidden      int
 }
 type Data_settings struct {
 	ProxyList          []string
 	WorkingProxyList   []string
+	ProxyStats         []proxy_checker.ProxyStats
+	SuggestedProxy     string
 	ProxyCheckEnabled  bool
 	ProxyCheckInterval time.Duration
 	DefaultProxyServer string
+	ImageResizeEnabled bool
+	MuteList           core.MuteList
 }
 type Data_tag struct {
 	Title            string
@@ -151,6 +223,8 @@ type Data_tag struct {
 	ActiveMode       string
 	ActiveRatio      string
 	ActiveSearchMode string
+	Muted            bool // whether the tag is in the mute list
+	Hidden           int
 }
 type Data_user struct {
 	Title     string
@@ -159,6 +233,8 @@ type Data_user struct {
 	PageLimit int
 	Page      int
 	MetaImage string
+	Muted     bool // whether the user is in the mute list
+	Hidden    int
 }
 type Data_userAtom struct {
 	URL       string
@@ -176,8 +252,11 @@ type Data_mangaSeries struct {
 	User               core.UserBrief
 	Page               int
 	PageLimit          int
+	Hidden             int
 }
-type (
-	Data_diagnostics  struct{}
-	Data_unauthorized struct{}
-)
+type Data_diagnostics struct {
+	ImageCacheEnabled bool
+	ImageCache        image_proxy.CacheStats
+}
+
+type Data_unauthorized struct{}
@@ -1,12 +1,16 @@
 package routes
 
 import (
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
+	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/session"
 )
 
 type userPageData struct {
@@ -14,6 +18,7 @@ type userPageData struct {
 	category  core.UserArtCategory
 	pageLimit int
 	page      int
+	hidden    int
 }
 
 func fetchData(r *http.Request, getTags bool) (userPageData, error) {
@@ -38,6 +43,18 @@ func fetchData(r *http.Request, getTags bool) (userPageData, error) {
 		return userPageData{}, err
 	}
 
+	filter := core.GetFilter(r)
+	if category != core.UserArt_Bookmarks {
+		// Hiding everything on the page of a muted user they opened would be confusing
+		filter = filter.WithoutMutedUsers()
+	}
+	// Illustrations and Manga are subsets of Artworks, so they are not counted
+	var hiddenArtworks, hiddenNovels int
+	user.Artworks, hiddenArtworks = filter.Artworks(user.Artworks)
+	user.Illustrations, _ = filter.Artworks(user.Illustrations)
+	user.Manga, _ = filter.Artworks(user.Manga)
+	user.Novels, hiddenNovels = filter.Novels(user.Novels)
+
 	var worksCount int
 	var worksPerPage float64
 
@@ -55,6 +72,7 @@ func fetchData(r *http.Request, getTags bool) (userPageData, error) {
 		category:  category,
 		pageLimit: pageLimit,
 		page:      page,
+		hidden:    hiddenArtworks + hiddenNovels,
 	}, nil
 }
 
@@ -71,6 +89,8 @@ func UserPage(w http.ResponseWriter, r *http.Request) error {
 		PageLimit: data.pageLimit,
 		Page:      data.page,
 		MetaImage: data.user.BackgroundImage,
+		Muted:     core.GetMuteList(r).MutesUser(data.user.ID),
+		Hidden:    data.hidden,
 	})
 }
 
@@ -91,3 +111,71 @@ func UserAtomFeed(w http.ResponseWriter, r *http.Request) error {
 		// MetaImage: data.user.BackgroundImage,
 	})
 }
+
+// UserFollowingPage lists the users a user follows. The logged in user can also list their private follows.
+func UserFollowingPage(w http.ResponseWriter, r *http.Request) error {
+	return userFollowsPage(w, r, GetPathVar(r, "id"), "following")
+}
+
+// UserFollowersPage lists the users following a user.
+func UserFollowersPage(w http.ResponseWriter, r *http.Request) error {
+	return userFollowsPage(w, r, GetPathVar(r, "id"), "followers")
+}
+
+func userFollowsPage(w http.ResponseWriter, r *http.Request, id, kind string) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return i18n.Errorf("Invalid ID: %s", id)
+	}
+
+	page, err := strconv.Atoi(GetQueryParam(r, "page", "1"))
+	if err != nil || page < 1 {
+		return i18n.Error("Invalid page number.")
+	}
+
+	// The left part of the token is the member ID
+	self := false
+	if token := session.GetUserToken(r); token != "" {
+		self = strings.Split(token, "_")[0] == id
+	}
+
+	// private follows are only visible to the user themselves
+	rest := GetQueryParam(r, "rest", "show")
+	if rest != "show" && (rest != "hide" || !self) {
+		return i18n.Errorf("Invalid follow visibility: %s", rest)
+	}
+
+	user, err := core.GetUserBasicInformation(r, id)
+	if err != nil {
+		return err
+	}
+
+	var users []core.FollowUser
+	var total int
+	if kind == "following" {
+		users, total, err = core.GetUserFollowing(r, id, rest, page)
+	} else {
+		users, total, err = core.GetUserFollowers(r, id, page)
+	}
+	if err != nil {
+		return err
+	}
+	users, hidden := core.GetFilter(r).FollowUsers(users)
+
+	title := fmt.Sprintf("Following | %s", user.Name)
+	if kind == "followers" {
+		title = fmt.Sprintf("Followers | %s", user.Name)
+	}
+
+	return RenderHTML(w, r, Data_userFollows{
+		Title:     title,
+		User:      user,
+		Kind:      kind,
+		Users:     users,
+		Total:     total,
+		Rest:      rest,
+		Self:      self,
+		Page:      page,
+		PageLimit: max(1, int(math.Ceil(float64(total)/float64(core.FollowsPerPage)))),
+		Hidden:    hidden,
+	})
+}
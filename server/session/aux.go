@@ -25,7 +25,7 @@ func GetImageProxy(r *http.Request) url.URL {
 		// NOTE: do not use urlx for parsing here, a URI path like /proxy/i.pximg.net will fail to parse
 		// This will cause the default ProxyServer be silently used instead despite a UI change on the Settings page
 		proxyUrl, err := url.Parse(value)
-		if err != nil {
+		if err != nil || (proxyUrl.Scheme != "") != (proxyUrl.Host != "") {
 			// fall through to default case
 		} else {
 			return *proxyUrl
@@ -56,6 +56,9 @@ func GetImageProxyOrigin(r *http.Request) string {
 }
 
 func GetImageProxyPrefix(r *http.Request) string {
+	if GetCookie(r, Cookie_SmartProxy) == "true" {
+		return config.SmartProxyUrl
+	}
 	url := GetImageProxy(r)
 	return urlAuthority(url) + url.Path
 	// note: not sure if url.EscapedPath() is useful here. go's standard library is trash at handling URL (:// should be part of the scheme)
@@ -65,7 +68,8 @@ func GetImageProxyPrefix(r *http.Request) string {
 func urlAuthority(url url.URL) string {
 	r := ""
 	if (url.Scheme != "") != (url.Host != "") {
-		log.Panicf("url must have both scheme and authority or neither: %s", url.String())
+		log.Printf("url must have both scheme and authority or neither: %s", url.String())
+		return ""
 	}
 	if url.Scheme != "" {
 		r += url.Scheme + "://"
@@ -73,3 +77,13 @@ func urlAuthority(url url.URL) string {
 	r += url.Host
 	return r
 }
+
+// UnproxyImageUrl reverses ProxyImageUrlNoEscape for a single i.pximg.net URL,
+// returning the URL on i.pximg.net itself. URLs not pointing to the user's image proxy are returned unchanged.
+func UnproxyImageUrl(r *http.Request, s string) string {
+	proxyOrigin := GetImageProxyPrefix(r)
+	if rest, ok := strings.CutPrefix(s, proxyOrigin); ok && proxyOrigin != "" {
+		return "https://i.pximg.net/" + strings.TrimLeft(rest, "/")
+	}
+	return s
+}
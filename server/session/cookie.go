@@ -4,6 +4,8 @@ package session
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -13,6 +15,8 @@ const ( // the __Host thing force it to be secure and same-origin (no subdomain)
 	Cookie_Token             CookieName = "pixivfe-Token"
 	Cookie_CSRF              CookieName = "pixivfe-CSRF"
 	Cookie_ImageProxy        CookieName = "pixivfe-ImageProxy"
+	Cookie_ImageProfile      CookieName = "pixivfe-ImageProfile"
+	Cookie_SmartProxy        CookieName = "pixivfe-SmartProxy"
 	Cookie_NovelFontType     CookieName = "pixivfe-NovelFontType"
 	Cookie_NovelViewMode     CookieName = "pixivfe-NovelViewMode"
 	Cookie_ThumbnailToNewTab CookieName = "pixivfe-ThumbnailToNewTab"
@@ -20,7 +24,12 @@ const ( // the __Host thing force it to be secure and same-origin (no subdomain)
 	Cookie_HideArtR18        CookieName = "pixivfe-HideArtR18"
 	Cookie_HideArtR18G       CookieName = "pixivfe-HideArtR18G"
 	Cookie_HideArtAI         CookieName = "pixivfe-HideArtAI"
+	Cookie_MutedTags         CookieName = "pixivfe-MutedTags"
+	Cookie_MutedUsers        CookieName = "pixivfe-MutedUsers"
 	Cookie_Locale            CookieName = "pixivfe-Locale"
+
+	// Set by the proof-of-work challenge. Not a user setting, so it's not in AllCookieNames
+	Cookie_Clearance CookieName = "pixivfe-Clearance"
 )
 
 // Go can't make this a const...
@@ -28,6 +37,8 @@ var AllCookieNames []CookieName = []CookieName{
 	Cookie_Token,
 	Cookie_CSRF,
 	Cookie_ImageProxy,
+	Cookie_ImageProfile,
+	Cookie_SmartProxy,
 	Cookie_NovelFontType,
 	Cookie_NovelViewMode,
 	Cookie_ThumbnailToNewTab,
@@ -35,6 +46,8 @@ var AllCookieNames []CookieName = []CookieName{
 	Cookie_HideArtR18,
 	Cookie_HideArtR18G,
 	Cookie_HideArtAI,
+	Cookie_MutedTags,
+	Cookie_MutedUsers,
 	Cookie_Locale,
 }
 
@@ -46,6 +59,25 @@ func GetCookie(r *http.Request, name CookieName) string {
 	return cookie.Value
 }
 
+// GetCookieList reads a cookie written by SetCookieList.
+func GetCookieList(r *http.Request, name CookieName) []string {
+	value, err := url.QueryUnescape(GetCookie(r, name))
+	if err != nil || value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// SetCookieList saves a list of strings in a cookie.
+// Cookies can't hold most characters, so the list is escaped.
+func SetCookieList(w http.ResponseWriter, name CookieName, values []string) {
+	if len(values) == 0 {
+		ClearCookie(w, name)
+		return
+	}
+	SetCookie(w, name, url.QueryEscape(strings.Join(values, "\n")))
+}
+
 func SetCookie(w http.ResponseWriter, name CookieName, value string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:  string(name),
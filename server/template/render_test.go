@@ -26,6 +26,9 @@ func TestAutoRender(t *testing.T) {
 	test[Data_about](t)
 	test[Data_artwork](t)
 	test[Data_artworkMulti](t)
+	test[Data_bookmarks](t, Data_bookmarks{Rest: "show", Page: 1, PageLimit: 1})
+	test[Data_challenge](t)
+	test[Data_comments](t)
 	test[Data_diagnostics](t)
 	test[Data_discovery](t)
 	test[Data_error](t, Data_error{Title: fakeData[string](), Error: io.EOF})
@@ -37,12 +40,15 @@ func TestAutoRender(t *testing.T) {
 	test[Data_pixivisionArticle](t)
 	test[Data_pixivisionIndex](t)
 	test[Data_rank](t)
+	test[Data_rateLimited](t)
 	test[Data_rankingCalendar](t)
 	test[Data_settings](t)
 	test[Data_tag](t)
 	test[Data_unauthorized](t)
 	test[Data_user](t)
 	test[Data_userAtom](t)
+	test[Data_userDiscovery](t)
+	test[Data_userFollows](t, Data_userFollows{Kind: "following", Rest: "show", Self: true, Page: 1, PageLimit: 1})
 	test[Data_novelSeries](t)
 	test[Data_mangaSeries](t)
 }
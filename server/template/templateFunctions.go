@@ -2,6 +2,7 @@ package template
 
 import (
 	"fmt"
+	"html"
 	"math"
 	"math/rand"
 	"net/url"
@@ -11,6 +12,7 @@ import (
 
 	"codeberg.org/vnpower/pixivfe/v2/core"
 	"codeberg.org/vnpower/pixivfe/v2/i18n"
+	"codeberg.org/vnpower/pixivfe/v2/server/image_proxy"
 )
 
 // HTML is an alias for the HTML type from the core package
@@ -46,8 +48,16 @@ func GetRandomColor() string {
 	return colors[rand.Intn(len(colors))]
 }
 
-// ParseEmojis replaces emoji shortcodes in a string with corresponding image tags
+var lineBreakRegex = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// ParseEmojis escapes a comment and replaces emoji shortcodes in it with corresponding image tags.
+//
+// Comments may come with HTML entities and <br /> line breaks, which are kept as text and line breaks.
 func ParseEmojis(s string) HTML {
+	s = lineBreakRegex.ReplaceAllString(s, "\n")
+	s = html.EscapeString(html.UnescapeString(s))
+	s = strings.ReplaceAll(s, "\n", "<br />")
+
 	// Map of emoji shortcodes to their corresponding image IDs
 	emojiList := map[string]string{
 		"normal":        "101",
@@ -317,14 +327,6 @@ func SwitchButtonAttributes(baseURL, selection, currentSelection string) string
 	return fmt.Sprintf(`href=%s%s class=switch-button selected=%s`, baseURL, selection, cur)
 }
 
-var (
-	furiganaPattern = regexp.MustCompile(`\[\[rb:\s*(.+?)\s*>\s*(.+?)\s*\]\]`)
-	chapterPattern  = regexp.MustCompile(`\[chapter:\s*(.+?)\s*\]`)
-	jumpUriPattern  = regexp.MustCompile(`\[\[jumpuri:\s*(.+?)\s*>\s*(.+?)\s*\]\]`)
-	jumpPagePattern = regexp.MustCompile(`\[jump:\s*(\d+?)\s*\]`)
-	newPagePattern  = regexp.MustCompile(`\s*\[newpage\]\s*`)
-)
-
 // GetTemplateFunctions returns a map of custom template functions for use in HTML templates
 func GetTemplateFunctions() map[string]any {
 	return map[string]any{
@@ -386,44 +388,11 @@ func GetTemplateFunctions() map[string]any {
 			// Remove the last 6 characters from the string (assumes "_embed" suffix)
 			return s[:len(s)-6]
 		},
-		"renderNovel": func(s string) HTML {
-			// Replace furigana markup with HTML ruby tags
-			furiganaTemplate := `<ruby>$1<rp>(</rp><rt>$2</rt><rp>)</rp></ruby>`
-			s = furiganaPattern.ReplaceAllString(s, furiganaTemplate)
-
-			// Replace chapter markup with HTML h2 tags
-			chapterTemplate := `<h2>$1</h2>`
-			s = chapterPattern.ReplaceAllString(s, chapterTemplate)
-
-			// Replace jump URI markup with HTML anchor tags
-			jumpUriTemplate := `<a href="$2" target="_blank">$1</a>`
-			s = jumpUriPattern.ReplaceAllString(s, jumpUriTemplate)
-
-			// Replace jump page markup with HTML anchor tags
-			jumpPageTemplate := `<a href="#$1">To page $1</a>`
-			s = jumpPagePattern.ReplaceAllString(s, jumpPageTemplate)
-
-			// Handle newpage markup
-			if strings.Contains(s, "[newpage]") {
-				// Prepend <hr id="1"/> to the page if [newpage] is present
-				s = `<hr id="1"/>` + s
-				pageIdx := 1
-
-				// Should run before replace `\n` -> `<br />`
-				s = newPagePattern.ReplaceAllStringFunc(s, func(_ string) string {
-					pageIdx += 1
-					return fmt.Sprintf(`<br /><hr id="%d"/>`, pageIdx)
-				})
-			}
-
-			// Replace newlines with HTML line breaks
-			s = strings.ReplaceAll(s, "\n", "<br />")
-			return HTML(s)
-		},
 		"novelGenre": GetNovelGenre,
 		"floor": func(i float64) int {
 			return int(math.Floor(i))
 		},
+		"resizedImage":    image_proxy.ResizedURL,
 		"unfinishedQuery": UnfinishedQuery,
 		"replaceQuery":    ReplaceQuery,
 		// TODO: what is AttrGen for
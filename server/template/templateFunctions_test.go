@@ -158,3 +158,22 @@ func TestCreatePaginator(t *testing.T) {
 		})
 	}
 }
+
+func TestParseEmojis(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected HTML
+	}{
+		{"nice(heart)", `nice<img src="/proxy/s.pximg.net/common/images/emoji/501.png" alt="(heart)" class="emoji" />`},
+		{"(unknown) text", "(unknown) text"},
+		{"a<br />b\nc", "a<br />b<br />c"},
+		{`<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"it&#39;s &amp; fine", "it&#39;s &amp; fine"},
+	}
+
+	for _, tt := range tests {
+		if got := ParseEmojis(tt.input); got != tt.expected {
+			t.Errorf("ParseEmojis(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
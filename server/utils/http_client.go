@@ -1,6 +1,12 @@
 package utils
 
-import "net/http"
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+)
 
 // HttpClient is a pre-configured http.Client.
 // It serves as a base HTTP client used across different packages.
@@ -11,3 +17,43 @@ var HttpClient = &http.Client{
 		MaxIdleConnsPerHost: 20,
 	},
 }
+
+// PublicHttpClient is like HttpClient, but refuses to connect to loopback, private, link-local
+// and other non-public addresses, including after redirects.
+// Use it for URLs supplied by users, such as custom image proxies, so that they can't reach into the host's network.
+var PublicHttpClient = &http.Client{
+	Transport: &http.Transport{
+		// no proxy: the address checked must be the one actually connected to
+		DialContext:         (&net.Dialer{Control: rejectNonPublicAddress}).DialContext,
+		MaxIdleConns:        0,
+		MaxIdleConnsPerHost: 20,
+	},
+}
+
+var ErrNonPublicAddress = errors.New("refusing to connect to a non-public address")
+
+// cgnat is the shared address space of carrier-grade NAT, which isn't covered by netip.Addr.IsPrivate
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublicAddress reports whether ip is a globally reachable unicast address.
+func IsPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
+
+// rejectNonPublicAddress is called with the resolved address right before connecting,
+// so a hostname resolving to a private address is caught as well.
+func rejectNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !IsPublicAddress(ip) {
+		return ErrNonPublicAddress
+	}
+	return nil
+}
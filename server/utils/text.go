@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlLineBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// HTMLToText turns the HTML of a Pixiv description or caption into plain text.
+func HTMLToText(s string) string {
+	s = htmlLineBreak.ReplaceAllString(s, "\n")
+	s = htmlTag.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}